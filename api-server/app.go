@@ -1,101 +1,46 @@
 package main
 
 import (
-    "database/sql"
-    "encoding/json"
-    "fmt"
-    "github.com/prometheus/client_golang/prometheus"
-    "go-pg-bench/api-server/controllers"
+    "go-pg-bench/api-server/handlers"
     "go-pg-bench/common"
-    "io"
-    "log"
+    "go-pg-bench/config"
+    "go-pg-bench/store"
+    "log/slog"
     "net/http"
-    "time"
 )
 
-var (
-    collector = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Name: "api_server_metric_collector",
-            Help: "Collect metric related inserting job in api server",
-        },
-        []string{"count"},
-    )
-)
-
-var db *sql.DB
-
 func main() {
-    common.LoadEnv()
-    db = common.GetDBConnection()
+    var cfg config.APIServer
+    config.MustLoad(&cfg)
+    common.SetupLogger("api-server", cfg.Logging)
+    db := common.ConnectDB(cfg.Database)
     defer func() {
         if err := db.Close(); err != nil {
-            log.Fatal(err)
+            common.Fatal("Failed to close the database", common.ErrorAttr(err))
         }
     }()
-
-    prometheus.MustRegister(collector)
-    http.HandleFunc("/ping", pingHandler)
-    http.HandleFunc("/schedule-job", scheduleJobHandler)
-
-    fmt.Println("Starting server at port 8081")
-    if err := http.ListenAndServe(":8081", nil); err != nil {
-        log.Fatal(err)
-    }
-}
-
-func pingHandler(w http.ResponseWriter, r *http.Request) {
-    if r.Method != "GET" {
-        http.Error(w, "Method is not supported.", http.StatusNotFound)
-        return
-    }
-
-    if _, err := fmt.Fprintf(w, "pong"); err != nil {
-        return
-    }
-}
-
-func scheduleJobHandler(w http.ResponseWriter, r *http.Request) {
-    if r.Method != "POST" {
-        http.Error(w, "Method is not supported.", http.StatusNotFound)
-        return
-    }
-
-    var body controllers.ScheduleJobRequest
-    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-    defer func(Body io.ReadCloser) {
-        err := Body.Close()
-        if err != nil {
-            panic(err)
-        }
-    }(r.Body)
-
-    sequence, err := controllers.ParseSequence(body)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-
-    jobs, err := controllers.CalculateNextJobs(*sequence, time.Now().UTC())
+    jobStore, err := store.NewPostgres(db, store.PostgresOptions{Actor: common.ActorName("api-server"), BatchParameters: cfg.BatchParameters})
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    if err = controllers.InsertJobs(jobs, *sequence, db, collector); err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    if err = controllers.ReportJobStatus(db, collector); err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    if _, err := fmt.Fprintf(w, "Jobs scheduled for sequence"); err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-    }
+        common.Fatal("Failed to create the job store", common.ErrorAttr(err))
+    }
+
+    handlers.RegisterMetrics()
+    flushMetrics := common.StartMetrics("api_server", http.DefaultServeMux, cfg.Metrics)
+    defer flushMetrics()
+    flushTraces := common.StartTracing("api-server", cfg.Tracing)
+    defer flushTraces()
+    common.NewHealth(db, 0).Register(http.DefaultServeMux)
+    handlers.Register(http.DefaultServeMux, handlers.Options{
+        JobStore:         jobStore,
+        Notify:           common.NotifyOn(db),
+        MetadataMaxBytes: cfg.MetadataMaxBytes,
+    })
+
+    // On SIGTERM stop accepting connections and let in-flight requests finish until the shutdown timeout,
+    // the contexts of the requests still running then are cancelled
+    stop, drain := common.ShutdownContexts(cfg.Shutdown.Timeout)
+    if err = handlers.Serve(stop, drain, cfg.Addr, http.DefaultServeMux); err != nil {
+        common.Fatal("Failed to serve HTTP", common.ErrorAttr(err))
+    }
+    slog.Info("Shutting down...")
 }
@@ -4,7 +4,6 @@ import (
     "errors"
     "fmt"
     "go-pg-bench/entity"
-    "math/rand"
     "time"
 )
 
@@ -33,13 +32,21 @@ func CalculateNextJobs(sequence entity.Sequence, startedAt time.Time) ([]entity.
 
         if step.StepType() == entity.StepTypeJob {
             s := step.(*entity.StepJob)
+            expiresAt, err := jobExpiresAt(s, startedAt)
+            if err != nil {
+                return []entity.Job{}, err
+            }
             // schedule job at this time
             job := entity.Job{
-                DueAt:    startedAt,
-                Status:   entity.JobStatusInitialized,
-                Metadata: s.Metadata,
-                Priority: 0, // getPriority(), // TODO: calculate priority
-                TenantId: 1, // TODO: join tenant id
+                DueAt:      startedAt,
+                Status:     entity.JobStatusInitialized,
+                Metadata:   s.Metadata,
+                Priority:   sequence.TenantType.Priority(),
+                TenantId:   sequence.TenantId,
+                TenantType: sequence.TenantType,
+
+                ExpiresAt:    expiresAt,
+                Dependencies: s.JobDependencySpec,
             }
             jobs = append(jobs, job)
         }
@@ -47,11 +54,38 @@ func CalculateNextJobs(sequence entity.Sequence, startedAt time.Time) ([]entity.
     return jobs, nil
 }
 
-// getPriority returns a random number between 1 and 3
-// Assume we are getting this number from tenant type, 0 = new_tenants, 1 = sme, 2 = enterprise
-// The priority will be managed by other service
-func getPriority() int {
-    return rand.Intn(3) + 1
+// jobExpiresAt returns the earliest of the step's expires_at and due time plus max lateness, nil when neither is set
+func jobExpiresAt(s *entity.StepJob, dueAt time.Time) (*time.Time, error) {
+    var expiresAt *time.Time
+    if s.ExpiresAt != "" {
+        t, err := time.Parse(time.RFC3339, s.ExpiresAt)
+        if err != nil {
+            return nil, errors.New(fmt.Sprintf("failed to parse expires_at %v, error: %s", s.ExpiresAt, err.Error()))
+        }
+        t = t.UTC()
+        expiresAt = &t
+    }
+    if s.MaxLatenessPeriod > 0 {
+        t := dueAt.Add(time.Duration(s.MaxLatenessPeriod) * s.MaxLatenessUnit.ToDuration())
+        if expiresAt == nil || t.Before(*expiresAt) {
+            expiresAt = &t
+        }
+    }
+    return expiresAt, nil
+}
+
+// EarliestDueAt returns the due time of the job that runs first, false when there are no jobs
+func EarliestDueAt(jobs []entity.Job) (time.Time, bool) {
+    if len(jobs) == 0 {
+        return time.Time{}, false
+    }
+    earliest := jobs[0].DueAt
+    for _, job := range jobs[1:] {
+        if job.DueAt.Before(earliest) {
+            earliest = job.DueAt
+        }
+    }
+    return earliest, true
 }
 
 func GetNearestWeekDay(weekdays []entity.WeekDay, now time.Time) time.Time {
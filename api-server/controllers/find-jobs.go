@@ -0,0 +1,85 @@
+package controllers
+
+import (
+    "errors"
+    "fmt"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ParseJobFilter reads the query parameters of GET /jobs:
+// status (comma separated), tenant_id, sequence_id, subscriber, due_from, due_to (RFC3339),
+// metadata_key, metadata_value, cursor and limit.
+func ParseJobFilter(query url.Values) (store.JobFilter, error) {
+    filter := store.JobFilter{
+        SequenceId:  query.Get("sequence_id"),
+        MetadataKey: query.Get("metadata_key"),
+        Limit:       store.DefaultFindJobsLimit,
+    }
+    var err error
+
+    if rawStatus := query.Get("status"); rawStatus != "" {
+        for _, field := range strings.Split(rawStatus, ",") {
+            status, err := strconv.Atoi(strings.TrimSpace(field))
+            if err != nil {
+                return store.JobFilter{}, fmt.Errorf("status must be a comma separated list of integers, got %q", rawStatus)
+            }
+            filter.Statuses = append(filter.Statuses, entity.JobStatus(status))
+        }
+    }
+    if rawTenantId := query.Get("tenant_id"); rawTenantId != "" {
+        if filter.TenantId, err = strconv.Atoi(rawTenantId); err != nil {
+            return store.JobFilter{}, errors.New("tenant_id must be an integer")
+        }
+    }
+    if rawSubscriber := query.Get("subscriber"); rawSubscriber != "" {
+        subscriber, err := strconv.Atoi(rawSubscriber)
+        if err != nil {
+            return store.JobFilter{}, errors.New("subscriber must be an integer")
+        }
+        filter.Subscriber = &subscriber
+    }
+    if filter.DueFrom, err = parseQueryTime(query, "due_from"); err != nil {
+        return store.JobFilter{}, err
+    }
+    if filter.DueTo, err = parseQueryTime(query, "due_to"); err != nil {
+        return store.JobFilter{}, err
+    }
+    if query.Has("metadata_value") {
+        if filter.MetadataKey == "" {
+            return store.JobFilter{}, errors.New("metadata_value requires metadata_key")
+        }
+        metadataValue := query.Get("metadata_value")
+        filter.MetadataValue = &metadataValue
+    }
+    if rawCursor := query.Get("cursor"); rawCursor != "" {
+        cursor, err := store.ParseJobCursor(rawCursor)
+        if err != nil {
+            return store.JobFilter{}, err
+        }
+        filter.After = &cursor
+    }
+    if rawLimit := query.Get("limit"); rawLimit != "" {
+        if filter.Limit, err = strconv.Atoi(rawLimit); err != nil {
+            return store.JobFilter{}, errors.New("limit must be an integer")
+        }
+    }
+    return filter, nil
+}
+
+func parseQueryTime(query url.Values, key string) (*time.Time, error) {
+    raw := query.Get(key)
+    if raw == "" {
+        return nil, nil
+    }
+    t, err := time.Parse(time.RFC3339, raw)
+    if err != nil {
+        return nil, fmt.Errorf("%s must be an RFC3339 date: %w", key, err)
+    }
+    t = t.UTC()
+    return &t, nil
+}
@@ -1,71 +1,45 @@
 package controllers
 
 import (
-    "database/sql"
-    "fmt"
+    "context"
+    "errors"
     "github.com/prometheus/client_golang/prometheus"
     "go-pg-bench/common"
     "go-pg-bench/entity"
-    "log"
-    "os"
-    "strconv"
-    "strings"
-    "time"
+    "go-pg-bench/store"
 )
 
-func InsertJobs(jobTemplates []entity.Job, sequence entity.Sequence, db *sql.DB, collector *prometheus.GaugeVec) error {
-    start := time.Now()
+// JobsInserted counts the jobs inserted by InsertJobs, registered by the api-server
+var JobsInserted = prometheus.NewCounter(prometheus.CounterOpts{
+    Name: "api_server_jobs_inserted_total",
+    Help: "Jobs inserted by /schedule-job",
+})
+
+// InsertJobs inserts the jobs of every subscriber into jobStore, all of them or none.
+// It stops when ctx is done, e.g. when the client disconnects, and nothing is inserted then.
+func InsertJobs(ctx context.Context, jobStore store.JobStore, jobTemplates []entity.Job, sequence entity.Sequence) error {
+    logger := common.LoggerFrom(ctx).With(common.SequenceIdAttr(sequence.Id))
     jobTemplateCount := len(jobTemplates)
     if jobTemplateCount == 0 || sequence.Subscribers == 0 {
-        log.Println("No jobs to insert or no subscribers")
+        logger.Info("No jobs to insert or no subscribers")
         return nil
     }
+    logger.Info("Inserting jobs", "job_templates", jobTemplateCount, "subscribers", sequence.Subscribers, "jobs", jobTemplateCount*sequence.Subscribers)
 
-    totalJobs := jobTemplateCount * sequence.Subscribers
-    log.Printf("Inserting (%d) jobTemplates * total subscribers (%d) = (%d) jobs\n", jobTemplateCount, sequence.Subscribers, totalJobs)
-
-    maxParams, err := strconv.Atoi(os.Getenv("POSTGRES_SUPPORTED_BATCH_PARAMETERS"))
-    insertParamsCount := 4 // according to the number of column in query below
-    batchSize := maxParams / insertParamsCount
+    inserted, err := jobStore.InsertJobs(ctx, jobTemplates, sequence)
+    JobsInserted.Add(float64(inserted))
     if err != nil {
-        panic(err)
-    }
-
-    for batchSizeIndex := 0; batchSizeIndex < totalJobs; batchSizeIndex += batchSize {
-        endBatchIndex := min(batchSizeIndex+batchSize, totalJobs)
-
-        var query strings.Builder
-        query.WriteString("INSERT INTO jobs (due_at, status, priority, metadata) VALUES ")
-
-        var placeholders []string
-        var args []interface{}
-
-        // Create a placeholder for each job and append its values to the args slice
-        for batchItemIndex := batchSizeIndex; batchItemIndex < endBatchIndex; batchItemIndex++ {
-            // Cycle through the jobs array for each subscriber
-            jobIndex := batchItemIndex % jobTemplateCount
-            job := jobTemplates[jobIndex]
-
-            // Calculate placeholder indexes for SQL query
-            placeholderStartIndex := (batchItemIndex-batchSizeIndex)*insertParamsCount + 1
-            placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d)",
-                placeholderStartIndex, placeholderStartIndex+1, placeholderStartIndex+2, placeholderStartIndex+3))
-
-            // Append job details to args slice for query execution
-            args = append(args, job.DueAt, job.Status, job.Priority, job.Metadata)
+        if ctx.Err() != nil {
+            logger.Warn("Stopped inserting jobs", common.ErrorAttr(ctx.Err()))
+            return ctx.Err()
         }
-
-        query.WriteString(strings.Join(placeholders, ", "))
-        finalQuery := query.String()
-
-        _, err := db.Exec(finalQuery, args...)
-        if err != nil {
-            log.Printf("Failed to insert batch: %v\n", err)
+        var unresolved *store.UnresolvedDependenciesError
+        if errors.As(err, &unresolved) {
+            logger.Info("Rejected jobs with unresolved dependencies", common.ErrorAttr(err))
             return err
         }
-
-        insertRate := float64(endBatchIndex-batchSizeIndex) / time.Now().Sub(start).Seconds()
-        common.CollectMetric(collector, "new_job_inserted_rate", insertRate)
+        logger.Error("Failed to insert jobs", common.ErrorAttr(err))
+        return err
     }
     return nil
 }
@@ -1,33 +1,80 @@
 package controllers
 
 import (
+    "crypto/rand"
     "encoding/json"
     "errors"
     "fmt"
     "go-pg-bench/entity"
+    "time"
 )
 
 type ScheduleJobRequest struct {
     Steps       []map[string]interface{} `json:"steps"`
     Subscribers int                      `json:"subscribers"`
+    // TenantId defaults to entity.DefaultTenantId, TenantType is optional
+    TenantId   int               `json:"tenant_id"`
+    TenantType entity.TenantType `json:"tenant_type"`
 }
 
-func ParseSequence(body ScheduleJobRequest) (*entity.Sequence, error) {
+// DefaultMetadataMaxBytes is the default metadata size limit, see JOB_METADATA_MAX_BYTES
+const DefaultMetadataMaxBytes = 4096
+
+func ParseSequence(body ScheduleJobRequest, metadataMaxBytes int) (*entity.Sequence, error) {
+    sequenceId, err := newSequenceId()
+    if err != nil {
+        return &entity.Sequence{}, err
+    }
     sequence := entity.Sequence{
+        Id:          sequenceId,
         Subscribers: body.Subscribers,
         Steps:       []entity.Step{},
+        TenantId:    body.TenantId,
+        TenantType:  body.TenantType,
+    }
+    if sequence.TenantId == 0 {
+        sequence.TenantId = entity.DefaultTenantId
+    }
+    if sequence.TenantId < 0 {
+        return &entity.Sequence{}, fmt.Errorf("invalid tenant_id %d", body.TenantId)
+    }
+    if sequence.TenantType != "" && !sequence.TenantType.Valid() {
+        return &entity.Sequence{}, fmt.Errorf("invalid tenant_type %q, expected %q, %q or %q", body.TenantType,
+            entity.TenantTypeNew, entity.TenantTypeSme, entity.TenantTypeEnterprise)
     }
     for _, stepInterface := range body.Steps {
         step, err := UnmarshalStep(stepInterface)
         if err != nil {
             return &entity.Sequence{}, err
         }
+        if jobStep, ok := step.(*entity.StepJob); ok {
+            if err = ValidateMetadata(jobStep.Metadata, metadataMaxBytes); err != nil {
+                return &entity.Sequence{}, err
+            }
+            if err = ValidateDependencies(&jobStep.JobDependencySpec, metadataMaxBytes); err != nil {
+                return &entity.Sequence{}, err
+            }
+            if err = ValidateExpiry(jobStep); err != nil {
+                return &entity.Sequence{}, err
+            }
+        }
         sequence.Steps = append(sequence.Steps, step)
     }
 
     return &sequence, nil
 }
 
+// newSequenceId returns a random (version 4) UUID
+func newSequenceId() (string, error) {
+    var b [16]byte
+    if _, err := rand.Read(b[:]); err != nil {
+        return "", err
+    }
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
 func UnmarshalStep(stepInterface interface{}) (entity.Step, error) {
     stepMap, ok := stepInterface.(map[string]interface{})
     if !ok {
@@ -65,3 +112,61 @@ func UnmarshalStep(stepInterface interface{}) (entity.Step, error) {
 
     return step, nil
 }
+
+// ValidateMetadata checks that the job metadata is a JSON document within the size limit.
+// Empty metadata is allowed and stored as NULL.
+func ValidateMetadata(metadata string, maxBytes int) error {
+    if metadata == "" {
+        return nil
+    }
+    if maxBytes > 0 && len(metadata) > maxBytes {
+        return fmt.Errorf("metadata is %d bytes, exceeds the limit of %d bytes", len(metadata), maxBytes)
+    }
+    if !json.Valid([]byte(metadata)) {
+        return errors.New("metadata is not valid JSON")
+    }
+    return nil
+}
+
+// ValidateDependencies checks the dependency fields of a job step and defaults on_dependency_failure to cancel.
+// depends_on_metadata is matched by containment, so it has to be a JSON object.
+func ValidateDependencies(spec *entity.JobDependencySpec, maxBytes int) error {
+    for _, id := range spec.DependsOn {
+        if id <= 0 {
+            return fmt.Errorf("depends_on has an invalid job id %d", id)
+        }
+    }
+    if spec.DependsOnMetadata != "" {
+        if err := ValidateMetadata(spec.DependsOnMetadata, maxBytes); err != nil {
+            return fmt.Errorf("depends_on_metadata: %w", err)
+        }
+        var filter map[string]interface{}
+        if err := json.Unmarshal([]byte(spec.DependsOnMetadata), &filter); err != nil || filter == nil {
+            return errors.New("depends_on_metadata is not a JSON object")
+        }
+    }
+    switch spec.OnDependencyFailure {
+    case "":
+        spec.OnDependencyFailure = entity.DependencyFailureCancel
+    case entity.DependencyFailureCancel, entity.DependencyFailureSkip:
+    default:
+        return fmt.Errorf("unsupported on_dependency_failure: %s", spec.OnDependencyFailure)
+    }
+    return nil
+}
+
+// ValidateExpiry checks the optional expires_at and max lateness of a job step.
+func ValidateExpiry(step *entity.StepJob) error {
+    if step.ExpiresAt != "" {
+        if _, err := time.Parse(time.RFC3339, step.ExpiresAt); err != nil {
+            return fmt.Errorf("expires_at is not an RFC3339 date: %w", err)
+        }
+    }
+    if step.MaxLatenessPeriod < 0 {
+        return fmt.Errorf("max_lateness_period is negative: %d", step.MaxLatenessPeriod)
+    }
+    if step.MaxLatenessPeriod > 0 && step.MaxLatenessUnit.ToDuration() == 0 {
+        return fmt.Errorf("unsupported max_lateness_unit: %q", step.MaxLatenessUnit)
+    }
+    return nil
+}
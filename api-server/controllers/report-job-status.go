@@ -1,24 +1,20 @@
 package controllers
 
 import (
-    "database/sql"
+    "context"
     "github.com/prometheus/client_golang/prometheus"
     "go-pg-bench/common"
-    "go-pg-bench/entity"
-    "log"
+    "go-pg-bench/store"
+    "log/slog"
 )
 
-func ReportJobStatus(db *sql.DB, collector *prometheus.GaugeVec) error {
-    var count int
-    err := db.QueryRow(`
-      SELECT COUNT(id) AS count
-      FROM jobs
-      WHERE status = $1`, entity.JobStatusInitialized).Scan(&count)
+func ReportJobStatus(ctx context.Context, jobStore store.JobStore, collector *prometheus.GaugeVec) error {
+    count, err := jobStore.CountQueuedJobs(ctx)
     if err != nil {
         return err
     }
 
-    log.Println("Jobs in queue: ", count)
+    slog.Debug("Jobs in queue", "count", count)
     common.CollectMetric(collector, "jobs_in_queue", float64(count))
     return nil
 }
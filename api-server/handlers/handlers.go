@@ -0,0 +1,275 @@
+// Package handlers serves the HTTP API of the api-server, on the job store it is given.
+package handlers
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/prometheus/client_golang/prometheus"
+    "go-pg-bench/api-server/controllers"
+    "go-pg-bench/common"
+    "go-pg-bench/store"
+    "go.opentelemetry.io/otel/attribute"
+    "io"
+    "log/slog"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var Collector = prometheus.NewGaugeVec(
+    prometheus.GaugeOpts{
+        Name: "api_server_metric_collector",
+        Help: "Collect metric related inserting job in api server",
+    },
+    []string{"count"},
+)
+
+// RegisterMetrics registers the metrics of the API with the default registry.
+func RegisterMetrics() {
+    prometheus.MustRegister(Collector, controllers.JobsInserted)
+}
+
+type Options struct {
+    JobStore store.JobStore
+    // Notify wakes up the due-job checkers when jobs get scheduled
+    Notify           common.NotifyFunc
+    MetadataMaxBytes int
+}
+
+type api struct {
+    options Options
+}
+
+// Register mounts /ping, /schedule-job, /jobs and /jobs/{id} on mux.
+func Register(mux *http.ServeMux, options Options) {
+    a := &api{options: options}
+    mux.HandleFunc("/ping", a.ping)
+    mux.HandleFunc("/schedule-job", a.scheduleJob)
+    mux.HandleFunc("/jobs", a.listJobs)
+    mux.HandleFunc("/jobs/", a.getJob)
+}
+
+// Serve serves handler on addr until stop is done, then stops accepting connections and lets in-flight requests
+// finish until drain is done; the contexts of the requests still running then are cancelled.
+func Serve(stop context.Context, drain context.Context, addr string, handler http.Handler) error {
+    server := &http.Server{
+        Addr:        addr,
+        Handler:     withRequestLogger(handler),
+        BaseContext: func(net.Listener) context.Context { return drain },
+    }
+    shutdownDone := make(chan struct{})
+    go func() {
+        defer close(shutdownDone)
+        <-stop.Done()
+        if err := server.Shutdown(drain); err != nil {
+            slog.Error("Failed to drain in-flight requests", common.ErrorAttr(err))
+        }
+    }()
+
+    slog.Info("Starting server", "addr", addr)
+    if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        return err
+    }
+    <-shutdownDone
+    return nil
+}
+
+// withRequestLogger gives every request a request id, taken from the X-Request-Id header or generated,
+// and a logger carrying it in the request context for the handlers to log with
+func withRequestLogger(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requestId := r.Header.Get("X-Request-Id")
+        if requestId == "" {
+            requestId = newRequestId()
+        }
+        w.Header().Set("X-Request-Id", requestId)
+        logger := slog.Default().With(common.RequestIdAttr(requestId))
+        next.ServeHTTP(w, r.WithContext(common.ContextWithLogger(r.Context(), logger)))
+    })
+}
+
+func newRequestId() string {
+    id := make([]byte, 8)
+    _, _ = rand.Read(id)
+    return hex.EncodeToString(id)
+}
+
+func (a *api) ping(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        http.Error(w, "Method is not supported.", http.StatusNotFound)
+        return
+    }
+
+    if _, err := fmt.Fprintf(w, "pong"); err != nil {
+        return
+    }
+}
+
+func (a *api) scheduleJob(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        http.Error(w, "Method is not supported.", http.StatusNotFound)
+        return
+    }
+
+    // Each step gets a span, the jobs keep the trace context of InsertJobs for the due-job checker to link back to it
+    ctx, span := common.StartServerSpan(r, "POST /schedule-job")
+    defer span.End()
+
+    var body controllers.ScheduleJobRequest
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    defer func(Body io.ReadCloser) {
+        err := Body.Close()
+        if err != nil {
+            panic(err)
+        }
+    }(r.Body)
+
+    _, parseSpan := common.Tracer().Start(ctx, "ParseSequence")
+    sequence, err := controllers.ParseSequence(body, a.options.MetadataMaxBytes)
+    common.EndSpan(parseSpan, err)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    logger := common.LoggerFrom(r.Context()).With(common.SequenceIdAttr(sequence.Id))
+
+    span.SetAttributes(attribute.String("sequence.id", sequence.Id), attribute.Int("sequence.subscribers", sequence.Subscribers))
+
+    _, calculateSpan := common.Tracer().Start(ctx, "CalculateNextJobs")
+    jobs, err := controllers.CalculateNextJobs(*sequence, time.Now().UTC())
+    common.EndSpan(calculateSpan, err)
+    if err != nil {
+        logger.Error("Failed to calculate the next jobs", common.ErrorAttr(err))
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    insertCtx, insertSpan := common.Tracer().Start(ctx, "InsertJobs")
+    insertSpan.SetAttributes(attribute.Int("jobs.count", len(jobs)*sequence.Subscribers))
+    sequence.TraceParent = common.TraceParent(insertCtx)
+    err = controllers.InsertJobs(insertCtx, a.options.JobStore, jobs, *sequence)
+    common.EndSpan(insertSpan, err)
+    if err != nil && ctx.Err() != nil {
+        // the client went away or the shutdown deadline passed, there is nobody to answer
+        return
+    }
+    var unresolved *store.UnresolvedDependenciesError
+    if errors.As(err, &unresolved) {
+        http.Error(w, unresolved.Error(), http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        logger.Error("Failed to insert jobs", common.ErrorAttr(err))
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    // Wake up due-job checkers sleeping past the earliest new job, they fall back to polling if this fails
+    if earliestDueAt, ok := controllers.EarliestDueAt(jobs); ok && sequence.Subscribers > 0 {
+        if err = a.options.Notify(ctx, earliestDueAt); err != nil {
+            logger.Warn("Failed to notify scheduled jobs", common.ErrorAttr(err))
+        }
+    }
+
+    if err = controllers.ReportJobStatus(ctx, a.options.JobStore, Collector); err != nil {
+        logger.Error("Failed to report the job status", common.ErrorAttr(err))
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    logger.Info("Jobs scheduled", "jobs", len(jobs)*sequence.Subscribers)
+
+    if _, err := fmt.Fprintf(w, "Jobs scheduled for sequence %s", sequence.Id); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+func (a *api) listJobs(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        http.Error(w, "Method is not supported.", http.StatusNotFound)
+        return
+    }
+
+    filter, err := controllers.ParseJobFilter(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    page, err := a.options.JobStore.FindJobs(r.Context(), filter)
+    if err != nil {
+        common.LoggerFrom(r.Context()).Error("Failed to find jobs", common.ErrorAttr(err))
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if err = json.NewEncoder(w).Encode(page); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+func (a *api) getJob(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "GET" {
+        http.Error(w, "Method is not supported.", http.StatusNotFound)
+        return
+    }
+
+    // /jobs/{id} or /jobs/{id}/events
+    rawId, subresource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+    id, err := strconv.Atoi(rawId)
+    if err != nil {
+        http.Error(w, "job id must be an integer", http.StatusBadRequest)
+        return
+    }
+    if subresource == "events" {
+        a.jobEvents(w, r, id)
+        return
+    }
+    if subresource != "" {
+        http.NotFound(w, r)
+        return
+    }
+
+    job, err := a.options.JobStore.GetJob(r.Context(), id)
+    if errors.Is(err, store.ErrJobNotFound) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        common.LoggerFrom(r.Context()).Error("Failed to get job", common.JobIdAttr(id), common.ErrorAttr(err))
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if err = json.NewEncoder(w).Encode(job); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+func (a *api) jobEvents(w http.ResponseWriter, r *http.Request, id int) {
+    events, err := a.options.JobStore.GetJobEvents(r.Context(), id)
+    if errors.Is(err, store.ErrJobNotFound) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        common.LoggerFrom(r.Context()).Error("Failed to get job events", common.JobIdAttr(id), common.ErrorAttr(err))
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if err = json.NewEncoder(w).Encode(events); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+    "go-pg-bench/api-server/controllers"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "net/url"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestParseJobFilter(t *testing.T) {
+    subscriber := 3
+    metadataValue, emptyValue := "42", ""
+    dueFrom := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    cursor := store.JobCursor{DueAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Id: 7}
+
+    tests := []struct {
+        name      string
+        query     string
+        expected  store.JobFilter
+        expectErr bool
+    }{
+        {
+            name:     "No filter",
+            query:    "",
+            expected: store.JobFilter{Limit: store.DefaultFindJobsLimit},
+        },
+        {
+            name: "Every filter",
+            query: "status=0,3&tenant_id=2&sequence_id=abc&subscriber=3&due_from=2024-01-01T02:00:00%2B02:00" +
+                "&metadata_key=campaign&metadata_value=42&limit=10&cursor=" + cursor.Encode(),
+            expected: store.JobFilter{
+                Statuses:      []entity.JobStatus{entity.JobStatusInitialized, entity.JobStatusFailed},
+                TenantId:      2,
+                SequenceId:    "abc",
+                Subscriber:    &subscriber,
+                DueFrom:       &dueFrom,
+                MetadataKey:   "campaign",
+                MetadataValue: &metadataValue,
+                After:         &cursor,
+                Limit:         10,
+            },
+        },
+        {name: "Invalid status", query: "status=done", expectErr: true},
+        {name: "Invalid due_to", query: "due_to=tomorrow", expectErr: true},
+        {
+            name:     "Metadata key only",
+            query:    "metadata_key=campaign",
+            expected: store.JobFilter{MetadataKey: "campaign", Limit: store.DefaultFindJobsLimit},
+        },
+        {
+            name:     "Empty metadata value",
+            query:    "metadata_key=campaign&metadata_value=",
+            expected: store.JobFilter{MetadataKey: "campaign", MetadataValue: &emptyValue, Limit: store.DefaultFindJobsLimit},
+        },
+        {name: "Metadata value without key", query: "metadata_value=42", expectErr: true},
+        {name: "Invalid cursor", query: "cursor=xyz", expectErr: true},
+        {name: "Invalid limit", query: "limit=ten", expectErr: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            query, err := url.ParseQuery(tt.query)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            got, err := controllers.ParseJobFilter(query)
+            if tt.expectErr {
+                if err == nil {
+                    t.Fatalf("ParseJobFilter() expected error, got nil")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseJobFilter() error = %v", err)
+            }
+            if !reflect.DeepEqual(got, tt.expected) {
+                t.Errorf("ParseJobFilter() = %+v, want %+v", got, tt.expected)
+            }
+        })
+    }
+}
@@ -0,0 +1,54 @@
+package tests
+
+import (
+    "context"
+    "go-pg-bench/api-server/handlers"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestGetJobUnknownId(t *testing.T) {
+    jobStore := store.NewMemory("api-server-test")
+    templates := []entity.Job{{DueAt: time.Now(), Status: entity.JobStatusInitialized}}
+    if _, err := jobStore.InsertJobs(context.Background(), templates, entity.Sequence{Id: "sequence", Subscribers: 1}); err != nil {
+        t.Fatal(err)
+    }
+    page, err := jobStore.FindJobs(context.Background(), store.JobFilter{})
+    if err != nil || len(page.Jobs) != 1 {
+        t.Fatalf("FindJobs() = %+v, %v, want the job", page, err)
+    }
+    id := strconv.Itoa(page.Jobs[0].Id)
+    mux := http.NewServeMux()
+    handlers.Register(mux, handlers.Options{JobStore: jobStore})
+
+    for path, expected := range map[string]int{
+        "/jobs/" + id:             http.StatusOK,
+        "/jobs/" + id + "/events": http.StatusOK,
+        "/jobs/999":               http.StatusNotFound,
+        "/jobs/999/events":        http.StatusNotFound,
+    } {
+        recorder := httptest.NewRecorder()
+        mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+        if recorder.Code != expected {
+            t.Errorf("GET %s = %d, want %d", path, recorder.Code, expected)
+        }
+    }
+}
+
+func TestScheduleJobUnresolvedDependency(t *testing.T) {
+    mux := http.NewServeMux()
+    handlers.Register(mux, handlers.Options{JobStore: store.NewMemory("api-server-test")})
+
+    body := `{"steps": [{"type": "job", "metadata": "{}", "depends_on": [4242]}], "subscribers": 1}`
+    recorder := httptest.NewRecorder()
+    mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/schedule-job", strings.NewReader(body)))
+    if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "4242") {
+        t.Errorf("POST /schedule-job = %d %q, want 400 naming job 4242", recorder.Code, recorder.Body.String())
+    }
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+    "context"
+    "errors"
+    "go-pg-bench/api-server/controllers"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "testing"
+    "time"
+)
+
+func TestInsertJobs(t *testing.T) {
+    jobStore := store.NewMemory("api-server")
+    jobs := []entity.Job{
+        {DueAt: time.Now().UTC(), Status: entity.JobStatusInitialized},
+        {DueAt: time.Now().UTC().Add(time.Hour), Status: entity.JobStatusInitialized},
+    }
+    sequence := entity.Sequence{Id: "00000000-0000-0000-0000-000000000001", Subscribers: 3}
+
+    if err := controllers.InsertJobs(context.Background(), jobStore, jobs, sequence); err != nil {
+        t.Fatalf("InsertJobs() error = %v", err)
+    }
+    page, err := jobStore.FindJobs(context.Background(), store.JobFilter{SequenceId: sequence.Id})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(page.Jobs) != 6 {
+        t.Errorf("InsertJobs() inserted %d jobs, want 6", len(page.Jobs))
+    }
+}
+
+func TestInsertJobsStopsWhenCancelled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    jobs := []entity.Job{{DueAt: time.Now().UTC(), Status: entity.JobStatusInitialized}}
+    sequence := entity.Sequence{Id: "00000000-0000-0000-0000-000000000001", Subscribers: 3}
+
+    if err := controllers.InsertJobs(ctx, store.NewMemory("api-server"), jobs, sequence); !errors.Is(err, context.Canceled) {
+        t.Errorf("InsertJobs() with a cancelled context error = %v, want %v", err, context.Canceled)
+    }
+}
+
+func TestInsertJobsRejectsUnresolvedDependencies(t *testing.T) {
+    jobStore := store.NewMemory("api-server")
+    jobs := []entity.Job{{DueAt: time.Now().UTC(), Status: entity.JobStatusInitialized,
+        Dependencies: entity.JobDependencySpec{DependsOn: []int{42}}}}
+    sequence := entity.Sequence{Id: "00000000-0000-0000-0000-000000000001", Subscribers: 2}
+
+    err := controllers.InsertJobs(context.Background(), jobStore, jobs, sequence)
+    var unresolved *store.UnresolvedDependenciesError
+    if !errors.As(err, &unresolved) || len(unresolved.JobIds) != 1 || unresolved.JobIds[0] != 42 {
+        t.Fatalf("InsertJobs() error = %v, want the unresolved job 42", err)
+    }
+    if page, _ := jobStore.FindJobs(context.Background(), store.JobFilter{}); len(page.Jobs) != 0 {
+        t.Errorf("InsertJobs() inserted %d jobs, want none", len(page.Jobs))
+    }
+}
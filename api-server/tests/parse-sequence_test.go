@@ -0,0 +1,204 @@
+package tests
+
+import (
+    "encoding/json"
+    "go-pg-bench/api-server/controllers"
+    "go-pg-bench/entity"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseSequenceMetadata(t *testing.T) {
+    tests := []struct {
+        name             string
+        metadata         interface{}
+        maxBytes         int
+        expectedMetadata string
+        expectErr        bool
+    }{
+        {
+            name:             "JSON object",
+            metadata:         map[string]interface{}{"any": "thing"},
+            maxBytes:         100,
+            expectedMetadata: `{"any":"thing"}`,
+        },
+        {
+            name:             "String holding JSON",
+            metadata:         `{"any": "thing"}`,
+            maxBytes:         100,
+            expectedMetadata: `{"any": "thing"}`,
+        },
+        {
+            name:             "No metadata",
+            metadata:         nil,
+            maxBytes:         100,
+            expectedMetadata: "",
+        },
+        {
+            name:      "String that is not JSON",
+            metadata:  "{ 'any': 'thing' }",
+            maxBytes:  100,
+            expectErr: true,
+        },
+        {
+            name:      "Exceeds size limit",
+            metadata:  map[string]interface{}{"any": strings.Repeat("x", 100)},
+            maxBytes:  100,
+            expectErr: true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            body := toScheduleJobRequest(t, map[string]interface{}{
+                "subscribers": 1,
+                "steps": []map[string]interface{}{
+                    {"type": "job", "metadata": tt.metadata},
+                },
+            })
+
+            sequence, err := controllers.ParseSequence(body, tt.maxBytes)
+            if tt.expectErr {
+                if err == nil {
+                    t.Fatalf("ParseSequence() expected error, got nil")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseSequence() error = %v", err)
+            }
+
+            step := sequence.Steps[0].(*entity.StepJob)
+            if step.Metadata != tt.expectedMetadata {
+                t.Errorf("ParseSequence() metadata = %q, want %q", step.Metadata, tt.expectedMetadata)
+            }
+        })
+    }
+}
+
+func TestParseSequenceDependencies(t *testing.T) {
+    tests := []struct {
+        name         string
+        step         map[string]interface{}
+        expectedSpec entity.JobDependencySpec
+        expectErr    bool
+    }{
+        {
+            name: "Job ids default to cancel",
+            step: map[string]interface{}{"depends_on": []int{1, 2}},
+            expectedSpec: entity.JobDependencySpec{
+                DependsOn:           []int{1, 2},
+                OnDependencyFailure: entity.DependencyFailureCancel,
+            },
+        },
+        {
+            name: "Metadata filter with skip",
+            step: map[string]interface{}{
+                "depends_on_metadata":   map[string]interface{}{"campaign": 42},
+                "on_dependency_failure": "skip",
+            },
+            expectedSpec: entity.JobDependencySpec{
+                DependsOnMetadata:   `{"campaign":42}`,
+                OnDependencyFailure: entity.DependencyFailureSkip,
+            },
+        },
+        {
+            name: "Metadata filter as a string",
+            step: map[string]interface{}{"depends_on_metadata": `{"campaign": 42}`},
+            expectedSpec: entity.JobDependencySpec{
+                DependsOnMetadata:   `{"campaign": 42}`,
+                OnDependencyFailure: entity.DependencyFailureCancel,
+            },
+        },
+        {
+            name:      "Metadata filter that is not an object",
+            step:      map[string]interface{}{"depends_on_metadata": []int{42}},
+            expectErr: true,
+        },
+        {
+            name:      "Invalid job id",
+            step:      map[string]interface{}{"depends_on": []int{0}},
+            expectErr: true,
+        },
+        {
+            name:      "Unsupported failure policy",
+            step:      map[string]interface{}{"depends_on": []int{1}, "on_dependency_failure": "ignore"},
+            expectErr: true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.step["type"] = "job"
+            body := toScheduleJobRequest(t, map[string]interface{}{
+                "subscribers": 1,
+                "steps":       []map[string]interface{}{tt.step},
+            })
+
+            sequence, err := controllers.ParseSequence(body, controllers.DefaultMetadataMaxBytes)
+            if tt.expectErr {
+                if err == nil {
+                    t.Fatalf("ParseSequence() expected error, got nil")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseSequence() error = %v", err)
+            }
+
+            step := sequence.Steps[0].(*entity.StepJob)
+            if !reflect.DeepEqual(step.JobDependencySpec, tt.expectedSpec) {
+                t.Errorf("ParseSequence() dependencies = %+v, want %+v", step.JobDependencySpec, tt.expectedSpec)
+            }
+        })
+    }
+}
+
+// toScheduleJobRequest round-trips the payload through JSON like the api-server handler does
+func toScheduleJobRequest(t *testing.T, payload map[string]interface{}) controllers.ScheduleJobRequest {
+    raw, err := json.Marshal(payload)
+    if err != nil {
+        t.Fatalf("failed to marshal payload: %v", err)
+    }
+    var body controllers.ScheduleJobRequest
+    if err = json.Unmarshal(raw, &body); err != nil {
+        t.Fatalf("failed to unmarshal payload: %v", err)
+    }
+    return body
+}
+
+func TestParseSequenceTenant(t *testing.T) {
+    tests := []struct {
+        name         string
+        tenantId     int
+        tenantType   entity.TenantType
+        expectErr    bool
+        expectedId   int
+        expectedType entity.TenantType
+    }{
+        {name: "Default tenant", expectedId: entity.DefaultTenantId},
+        {name: "Tenant with type", tenantId: 42, tenantType: entity.TenantTypeSme, expectedId: 42, expectedType: entity.TenantTypeSme},
+        {name: "Unknown tenant type", tenantId: 42, tenantType: "gold", expectErr: true},
+        {name: "Negative tenant id", tenantId: -1, expectErr: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            body := controllers.ScheduleJobRequest{Subscribers: 1, TenantId: tt.tenantId, TenantType: tt.tenantType}
+            sequence, err := controllers.ParseSequence(body, controllers.DefaultMetadataMaxBytes)
+            if tt.expectErr {
+                if err == nil {
+                    t.Fatal("ParseSequence() expected error, got nil")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseSequence() error = %v", err)
+            }
+            if sequence.TenantId != tt.expectedId || sequence.TenantType != tt.expectedType {
+                t.Errorf("ParseSequence() tenant = %d %q, want %d %q", sequence.TenantId, sequence.TenantType, tt.expectedId, tt.expectedType)
+            }
+        })
+    }
+}
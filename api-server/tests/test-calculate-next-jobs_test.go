@@ -48,3 +48,55 @@ func TestCalculateNextJobs(t *testing.T) {
         }
     }
 }
+
+func TestCalculateNextJobsExpiry(t *testing.T) {
+    startedAt := time.Date(2023, 12, 28, 12, 0, 0, 0, time.UTC)
+
+    sequence := entity.Sequence{
+        Steps: []entity.Step{
+            &entity.StepJob{Metadata: "no expiry"},
+            &entity.StepJob{Metadata: "max lateness", MaxLatenessPeriod: 2, MaxLatenessUnit: entity.DelayUnitHour},
+            &entity.StepJob{Metadata: "expires at", ExpiresAt: "2023-12-28T13:00:00Z"},
+            // the earliest of the two applies
+            &entity.StepJob{Metadata: "both", ExpiresAt: "2023-12-28T13:00:00Z", MaxLatenessPeriod: 30, MaxLatenessUnit: entity.DelayUnitMinute},
+        },
+        Subscribers: 1,
+    }
+
+    expected := []*time.Time{
+        nil,
+        timePtr(time.Date(2023, 12, 28, 14, 0, 0, 0, time.UTC)),
+        timePtr(time.Date(2023, 12, 28, 13, 0, 0, 0, time.UTC)),
+        timePtr(time.Date(2023, 12, 28, 12, 30, 0, 0, time.UTC)),
+    }
+
+    got, err := controllers.CalculateNextJobs(sequence, startedAt)
+    if err != nil {
+        t.Fatalf("CalculateNextJobs() error = %v", err)
+    }
+    for i, job := range got {
+        if (job.ExpiresAt == nil) != (expected[i] == nil) || (job.ExpiresAt != nil && !job.ExpiresAt.Equal(*expected[i])) {
+            t.Errorf("Job %d expires at %v, want %v", i, job.ExpiresAt, expected[i])
+        }
+        if job.ExpiresAt != nil && job.IsExpired(job.ExpiresAt.Add(-time.Second)) {
+            t.Errorf("Job %d expired before its expires_at", i)
+        }
+    }
+}
+
+func TestCalculateNextJobsPriority(t *testing.T) {
+    for _, tenantType := range []entity.TenantType{entity.TenantTypeNew, entity.TenantTypeSme, entity.TenantTypeEnterprise, ""} {
+        sequence := entity.Sequence{Steps: []entity.Step{&entity.StepJob{Metadata: "job"}}, TenantType: tenantType}
+        got, err := controllers.CalculateNextJobs(sequence, time.Now())
+        if err != nil {
+            t.Fatalf("CalculateNextJobs() error = %v", err)
+        }
+        if len(got) != 1 || got[0].Priority != tenantType.Priority() {
+            t.Errorf("CalculateNextJobs() of tenant type %q = %+v, want priority %d", tenantType, got, tenantType.Priority())
+        }
+    }
+}
+
+func timePtr(t time.Time) *time.Time {
+    return &t
+}
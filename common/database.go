@@ -1,62 +1,107 @@
 package common
 
 import (
+    "context"
     "database/sql"
     "fmt"
     _ "github.com/lib/pq"
-    "log"
-    "os"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "go-pg-bench/config"
+    "log/slog"
+    "net/url"
+    "strings"
     "sync"
+    "time"
 )
 
 var (
     db   *sql.DB
     once sync.Once
+    // queryTimeout is the deadline WithQueryTimeout gives to the queries, set by OpenDB
+    queryTimeout time.Duration
 )
 
-func GetDBConnection() *sql.DB {
+// ConnectDB opens the shared connection and refuses to start the service
+// when the schema is behind the migrations embedded in the binary.
+// The statistics of its pool are exported as the go_sql_* metrics.
+func ConnectDB(cfg config.Database) *sql.DB {
     once.Do(func() {
-        var err error
-        db, err = sql.Open("postgres", os.Getenv("POSTGRES_CONNECTION_STRING"))
-        if err != nil {
-            log.Fatal(err)
-        }
-        log.Println("Successfully connected to database!")
-
-        if err = EnsureTable(db); err != nil {
-            log.Fatal(err)
+        db = OpenDB(cfg)
+        if err := RequireSchemaVersion(context.Background(), db); err != nil {
+            Fatal("Database schema is out of date", ErrorAttr(err))
         }
+        prometheus.MustRegister(collectors.NewDBStatsCollector(db, "go_pg_bench"))
     })
     return db
 }
 
-func EnsureTable(db *sql.DB) error {
-    _, err := db.Exec(`
-     CREATE TABLE IF NOT EXISTS PUBLIC.jobs
-     (
-         id        serial CONSTRAINT jobs_pk PRIMARY KEY,
-         due_at    TIMESTAMP DEFAULT NOW() NOT NULL,
-         priority  INTEGER   DEFAULT 0,
-         tenant_id INTEGER   DEFAULT 1,
-         status    INTEGER   DEFAULT 0,
-         metadata  VARCHAR(100)
-     );
-     
-     ALTER TABLE public.jobs
-         OWNER TO postgres;
-     
-     CREATE INDEX IF NOT EXISTS jobs_due_at_index
-         ON PUBLIC.jobs (due_at);
-     
-     CREATE INDEX IF NOT EXISTS jobs_priority_index
-         ON PUBLIC.jobs (priority);
-     
-     CREATE INDEX IF NOT EXISTS jobs_status_index
-         ON PUBLIC.jobs (status);
-    `)
+// GetDBConnection returns the shared connection opened by ConnectDB.
+func GetDBConnection() *sql.DB {
+    if db == nil {
+        panic("GetDBConnection called before ConnectDB")
+    }
+    return db
+}
+
+// OpenDB opens a connection pool without checking the schema version, for the migrate command.
+// It pings the database until it answers, backing off between attempts, and exits when it never does.
+func OpenDB(cfg config.Database) *sql.DB {
+    conn, err := sql.Open("postgres", ConnectionString(cfg))
     if err != nil {
-        return fmt.Errorf("error creating tables: %v", err)
+        Fatal("Failed to open the database", ErrorAttr(err))
+    }
+    conn.SetMaxOpenConns(cfg.MaxOpenConns)
+    conn.SetMaxIdleConns(cfg.MaxIdleConns)
+    conn.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+    conn.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+    queryTimeout = cfg.QueryTimeout
+
+    policy := FailurePolicy{
+        MaxConsecutiveFailures: cfg.ConnectAttempts,
+        InitialBackoff:         cfg.ConnectInitialBackoff,
+        MaxBackoff:             cfg.ConnectMaxBackoff,
+    }
+    attempt := 0
+    err = RetryTransient(context.Background(), policy, func() error {
+        attempt++
+        ctx, cancel := WithQueryTimeout(context.Background())
+        defer cancel()
+        err := conn.PingContext(ctx)
+        if err != nil {
+            slog.Warn("Failed to reach the database", "attempt", attempt, ErrorAttr(err))
+        }
+        return err
+    })
+    if err != nil {
+        Fatal("Failed to connect to the database", "attempts", attempt, ErrorAttr(err))
+    }
+    slog.Info("Successfully connected to database!")
+    return conn
+}
+
+// ConnectionString returns the connection string of cfg with the statement_timeout of the sessions.
+// lib/pq sends the parameters it does not know, such as statement_timeout, to the server at startup.
+func ConnectionString(cfg config.Database) string {
+    if cfg.StatementTimeout <= 0 {
+        return cfg.ConnectionString
+    }
+    timeout := fmt.Sprint(cfg.StatementTimeout.Milliseconds())
+    if u, err := url.Parse(cfg.ConnectionString); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+        query := u.Query()
+        query.Set("statement_timeout", timeout)
+        u.RawQuery = query.Encode()
+        return u.String()
+    }
+    // key=value connection string
+    return strings.TrimSpace(cfg.ConnectionString) + " statement_timeout=" + timeout
+}
+
+// WithQueryTimeout returns a copy of ctx with the deadline of one query or transaction, DB_QUERY_TIMEOUT_MS.
+// Every query of the services runs under it, so a hung connection fails the query instead of the service.
+func WithQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+    if queryTimeout <= 0 {
+        return context.WithCancel(ctx)
     }
-    log.Println("Tables ensured!")
-    return nil
+    return context.WithTimeout(ctx, queryTimeout)
 }
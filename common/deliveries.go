@@ -0,0 +1,41 @@
+package common
+
+import (
+    "context"
+    "database/sql"
+)
+
+// ProcessDeliveryOnce gives consumers of job messages idempotent processing on top of at-least-once delivery.
+// It records (consumer, deliveryId) and runs fn in the same transaction, so the effects of fn are committed
+// exactly once per delivery id; for a delivery processed before it returns false without calling fn.
+// The consumer's own writes must go through tx for the guarantee to hold; the transaction is rolled back when ctx is done.
+func ProcessDeliveryOnce(ctx context.Context, db *sql.DB, consumer string, deliveryId string, fn func(tx *sql.Tx) error) (bool, error) {
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.ExecContext(ctx, `
+      INSERT INTO consumer_deliveries (consumer, delivery_id)
+      VALUES ($1, $2)
+      ON CONFLICT (consumer, delivery_id) DO NOTHING`, consumer, deliveryId)
+    if err != nil {
+        return false, err
+    }
+    inserted, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    if inserted == 0 {
+        return false, nil
+    }
+
+    if err = fn(tx); err != nil {
+        return false, err
+    }
+    if err = tx.Commit(); err != nil {
+        return false, err
+    }
+    return true, nil
+}
@@ -0,0 +1,6 @@
+package common
+
+// ActorName identifies a worker process in job events, e.g. "job-fixer@host-1/42".
+func ActorName(service string) string {
+    return service + "@" + instanceName()
+}
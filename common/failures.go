@@ -0,0 +1,181 @@
+package common
+
+import (
+    "context"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "github.com/lib/pq"
+    "github.com/prometheus/client_golang/prometheus"
+    "go-pg-bench/config"
+    "io"
+    "net"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// workerErrors counts the failed worker iterations by error class
+var workerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Name: "worker_errors_total",
+    Help: "Failed worker iterations by error class, transient or permanent",
+}, []string{"class"})
+
+var registerWorkerMetrics sync.Once
+
+// RegisterWorkerMetrics registers the metrics of FailureTracker with the default registry,
+// once for the whole process since a process may run several workers.
+func RegisterWorkerMetrics() {
+    registerWorkerMetrics.Do(func() {
+        prometheus.MustRegister(workerErrors)
+    })
+}
+
+type ErrorClass string
+
+const (
+    // ErrorClassTransient errors are expected to go away on their own: lost connections, restarts, lock conflicts
+    ErrorClassTransient ErrorClass = "transient"
+    // ErrorClassPermanent errors will fail again on retry: bad SQL, constraint violations, missing tables
+    ErrorClassPermanent ErrorClass = "permanent"
+)
+
+// ClassifyError tells transient database and network errors apart from permanent ones.
+func ClassifyError(err error) ErrorClass {
+    if err == nil {
+        return ErrorClassPermanent
+    }
+
+    var pqErr *pq.Error
+    if errors.As(err, &pqErr) {
+        switch pqErr.Code.Class() {
+        case "08", // connection exception
+            "40", // transaction rollback: serialization failure, deadlock, row moved to another partition
+            "53": // insufficient resources: too many connections, out of memory, disk full
+            return ErrorClassTransient
+        }
+        switch pqErr.Code {
+        case "55P03", // lock not available
+            "57014", // query canceled, e.g. statement timeout
+            "57P01", // admin shutdown
+            "57P02", // crash shutdown
+            "57P03": // cannot connect now, the server is starting up
+            return ErrorClassTransient
+        }
+        return ErrorClassPermanent
+    }
+
+    var netErr net.Error
+    if errors.Is(err, driver.ErrBadConn) ||
+        errors.Is(err, io.EOF) ||
+        errors.Is(err, io.ErrUnexpectedEOF) ||
+        errors.Is(err, syscall.ECONNREFUSED) ||
+        errors.Is(err, syscall.ECONNRESET) ||
+        errors.Is(err, context.DeadlineExceeded) ||
+        errors.As(err, &netErr) {
+        return ErrorClassTransient
+    }
+    // lib/pq reports some connection failures as plain errors
+    if strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "bad connection") {
+        return ErrorClassTransient
+    }
+    return ErrorClassPermanent
+}
+
+type FailurePolicy struct {
+    // MaxConsecutiveFailures is how many failures in a row a worker tolerates before giving up
+    MaxConsecutiveFailures int
+    InitialBackoff         time.Duration
+    MaxBackoff             time.Duration
+}
+
+func NewFailurePolicy(cfg config.Retry) FailurePolicy {
+    return FailurePolicy{
+        MaxConsecutiveFailures: cfg.MaxConsecutiveFailures,
+        InitialBackoff:         cfg.InitialBackoff,
+        MaxBackoff:             cfg.MaxBackoff,
+    }
+}
+
+// Backoff returns the delay before the next attempt after the given number of consecutive failures.
+func (p FailurePolicy) Backoff(failures int) time.Duration {
+    delay := p.InitialBackoff
+    for i := 1; i < failures && delay < p.MaxBackoff; i++ {
+        delay *= 2
+    }
+    return min(delay, p.MaxBackoff)
+}
+
+// FailureTracker counts the failures of a worker loop. It is not safe for concurrent use.
+type FailureTracker struct {
+    policy      FailurePolicy
+    consecutive int
+    Transient   int
+    Permanent   int
+}
+
+func NewFailureTracker(policy FailurePolicy) *FailureTracker {
+    return &FailureTracker{policy: policy}
+}
+
+// Failure records err and returns how long to wait before the next iteration. Transient errors back off
+// exponentially, permanent ones wait the maximum backoff since retrying right away would fail again.
+// A non-nil fatal error means the consecutive-failure threshold was reached and the worker should exit.
+func (t *FailureTracker) Failure(err error) (backoff time.Duration, fatal error) {
+    t.consecutive++
+    class := ClassifyError(err)
+    workerErrors.WithLabelValues(string(class)).Inc()
+    if class == ErrorClassTransient {
+        t.Transient++
+        backoff = t.policy.Backoff(t.consecutive)
+    } else {
+        t.Permanent++
+        backoff = t.policy.MaxBackoff
+    }
+    if t.policy.MaxConsecutiveFailures > 0 && t.consecutive >= t.policy.MaxConsecutiveFailures {
+        return backoff, fmt.Errorf("giving up after %d consecutive failures, last one %s: %w", t.consecutive, class, err)
+    }
+    return backoff, nil
+}
+
+// Success resets the consecutive failure count.
+func (t *FailureTracker) Success() {
+    t.consecutive = 0
+}
+
+// BackOff records a failed worker iteration and waits before the next one.
+// It returns the fatal error once the worker should give up.
+func (t *FailureTracker) BackOff(ctx context.Context, err error) error {
+    delay, fatal := t.Failure(err)
+    if fatal != nil {
+        return fatal
+    }
+    _ = Sleep(ctx, delay)
+    return nil
+}
+
+// RetryTransient runs fn until it succeeds, fails permanently, or failed MaxConsecutiveFailures times in a row.
+func RetryTransient(ctx context.Context, policy FailurePolicy, fn func() error) error {
+    for attempt := 1; ; attempt++ {
+        err := fn()
+        if err == nil || ClassifyError(err) == ErrorClassPermanent || attempt >= max(policy.MaxConsecutiveFailures, 1) {
+            return err
+        }
+        if sleepErr := Sleep(ctx, policy.Backoff(attempt)); sleepErr != nil {
+            return err
+        }
+    }
+}
+
+// Sleep waits for d unless ctx is done first.
+func Sleep(ctx context.Context, d time.Duration) error {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "sync/atomic"
+    "time"
+)
+
+// healthCheckTimeout bounds the database checks of /readyz
+const healthCheckTimeout = 2 * time.Second
+
+// Health serves /healthz and /readyz. /healthz succeeds as long as the process serves HTTP.
+// /readyz succeeds when the database is reachable, its schema is at the version the binary was built against
+// and, for services with a worker loop, the loop called Progress within maxStall.
+type Health struct {
+    db       *sql.DB
+    maxStall time.Duration
+    // lastProgress is the unix nano time of the last Progress call
+    lastProgress atomic.Int64
+}
+
+// NewHealth returns the health of a service using db, a zero maxStall disables the progress check
+// for services without a worker loop. A nil db skips the database checks, for services without a database.
+func NewHealth(db *sql.DB, maxStall time.Duration) *Health {
+    h := &Health{db: db, maxStall: maxStall}
+    h.Progress()
+    return h
+}
+
+// Progress records that the worker loop completed an iteration without failing.
+func (h *Health) Progress() {
+    h.lastProgress.Store(time.Now().UnixNano())
+}
+
+// CheckProgress fails when the worker loop made no progress within maxStall at now.
+func (h *Health) CheckProgress(now time.Time) error {
+    if h.maxStall <= 0 {
+        return nil
+    }
+    if stalled := now.Sub(time.Unix(0, h.lastProgress.Load())); stalled > h.maxStall {
+        return fmt.Errorf("worker loop made no progress for %s", stalled.Round(time.Second))
+    }
+    return nil
+}
+
+// Ready runs the checks of /readyz.
+func (h *Health) Ready(ctx context.Context) error {
+    if err := h.CheckProgress(time.Now()); err != nil {
+        return err
+    }
+    if h.db == nil {
+        return nil
+    }
+    ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+    defer cancel()
+    if err := h.db.PingContext(ctx); err != nil {
+        return fmt.Errorf("database unreachable: %w", err)
+    }
+    return RequireSchemaVersion(ctx, h.db)
+}
+
+// Register mounts /healthz and /readyz on mux.
+func (h *Health) Register(mux *http.ServeMux) {
+    RegisterHealth(mux, h)
+}
+
+// RegisterHealth mounts /healthz and /readyz on mux, /readyz succeeds when every one of healths is ready.
+// A process running several worker loops gives each its own Health, so a stalled loop is not hidden by the others.
+func RegisterHealth(mux *http.ServeMux, healths ...*Health) {
+    mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+        _, _ = fmt.Fprint(w, "ok")
+    })
+    mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+        for _, h := range healths {
+            if err := h.Ready(r.Context()); err != nil {
+                slog.Warn("Not ready", ErrorAttr(err))
+                http.Error(w, err.Error(), http.StatusServiceUnavailable)
+                return
+            }
+        }
+        _, _ = fmt.Fprint(w, "ok")
+    })
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+    "context"
+    "fmt"
+    "go-pg-bench/config"
+    "go-pg-bench/entity"
+    "io"
+    "log/slog"
+    "os"
+    "strings"
+)
+
+// Keys of the fields shared by the logs of every service
+const (
+    LogKeyService    = "service"
+    LogKeyWorkerId   = "worker_id"
+    LogKeyTenantId   = "tenant_id"
+    LogKeyJobId      = "job_id"
+    LogKeySequenceId = "sequence_id"
+    LogKeyRequestId  = "request_id"
+)
+
+const (
+    // LogFormatJSON writes one JSON object per record, the default
+    LogFormatJSON = "json"
+    // LogFormatText writes key=value records, easier to read in a terminal
+    LogFormatText = "text"
+)
+
+// SetupLogger makes a logger of NewLogger the default of both log/slog and log.
+func SetupLogger(service string, cfg config.Logging) *slog.Logger {
+    var level slog.Level
+    if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+        slog.Warn("Failed to parse LOG_LEVEL, using info", ErrorAttr(err))
+        level = slog.LevelInfo
+    }
+    logger, err := NewLogger(os.Stderr, service, level, cfg.Format)
+    if err != nil {
+        Fatal("Failed to set up the logger", ErrorAttr(err))
+    }
+    slog.SetDefault(logger)
+    return logger
+}
+
+// NewLogger returns a logger writing records of at least level to w, with the service and worker_id fields.
+// The worker id tells apart the replicas of a service, it is the same as the instance of the pushed metrics.
+func NewLogger(w io.Writer, service string, level slog.Level, format string) (*slog.Logger, error) {
+    options := &slog.HandlerOptions{Level: level}
+    var handler slog.Handler
+    switch strings.ToLower(format) {
+    case "", LogFormatJSON:
+        handler = slog.NewJSONHandler(w, options)
+    case LogFormatText:
+        handler = slog.NewTextHandler(w, options)
+    default:
+        return nil, fmt.Errorf("unsupported log format %q, expected %q or %q", format, LogFormatJSON, LogFormatText)
+    }
+    return slog.New(handler).With(LogKeyService, service, LogKeyWorkerId, instanceName()), nil
+}
+
+// Fatal logs msg at error level and exits, the slog counterpart of log.Fatal.
+func Fatal(msg string, args ...any) {
+    slog.Error(msg, args...)
+    os.Exit(1)
+}
+
+func JobIdAttr(id int) slog.Attr {
+    return slog.Int(LogKeyJobId, id)
+}
+
+func TenantIdAttr(id int) slog.Attr {
+    return slog.Int(LogKeyTenantId, id)
+}
+
+func SequenceIdAttr(id string) slog.Attr {
+    return slog.String(LogKeySequenceId, id)
+}
+
+func RequestIdAttr(id string) slog.Attr {
+    return slog.String(LogKeyRequestId, id)
+}
+
+// ErrorAttr is the field of a failure, under the same key everywhere
+func ErrorAttr(err error) slog.Attr {
+    return slog.Any("error", err)
+}
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying logger, e.g. one with the request_id of an HTTP request.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+    return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFrom returns the logger carried by ctx, the default logger when there is none.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+    if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+        return logger
+    }
+    return slog.Default()
+}
+
+// JobAttrs returns the job_id, tenant_id and sequence_id fields of job, leaving out those that are not set.
+func JobAttrs(job entity.Job) []any {
+    attrs := []any{JobIdAttr(job.Id)}
+    if job.TenantId != 0 {
+        attrs = append(attrs, TenantIdAttr(job.TenantId))
+    }
+    if job.SequenceId != "" {
+        attrs = append(attrs, SequenceIdAttr(job.SequenceId))
+    }
+    return attrs
+}
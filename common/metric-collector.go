@@ -1,17 +1,105 @@
 package common
 
 import (
+    "errors"
+    "fmt"
     "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
     "github.com/prometheus/client_golang/prometheus/push"
-    "log"
+    "go-pg-bench/config"
+    "log/slog"
+    "net/http"
     "os"
+    "time"
 )
 
+const (
+    // MetricsModeScrape exposes the metrics on /metrics for Prometheus to scrape, the default
+    MetricsModeScrape = config.MetricsModeScrape
+    // MetricsModePush pushes the metrics to the Pushgateway every METRICS_PUSH_INTERVAL_MS, for short-lived runs
+    MetricsModePush = config.MetricsModePush
+)
+
+// CollectMetric sets the gauge of metricName, it is read on the next scrape or push.
 func CollectMetric(c *prometheus.GaugeVec, metricName string, value float64) {
-    go func(name string, value float64) {
-        c.WithLabelValues(name).Set(value)
-        if err := push.New(os.Getenv("PUSH_GATEWAY_ENDPOINT"), "job_"+metricName).Collector(c).Push(); err != nil {
-            log.Println("Could not push completion time to Push gateway:", err)
+    c.WithLabelValues(metricName).Set(value)
+}
+
+// StartMetrics makes the registered metrics available according to the metrics mode. In scrape mode it mounts
+// /metrics on mux, the service's HTTP surface. In push mode it pushes to the Pushgateway under job until flush.
+// The returned flush stops the pushes and pushes once more in push mode, services call it before exiting so the last
+// samples are not lost.
+func StartMetrics(job string, mux *http.ServeMux, cfg config.Metrics) (flush func()) {
+    switch cfg.Mode {
+    case MetricsModeScrape:
+        mux.Handle("/metrics", MetricsHandler())
+        return func() {}
+    case MetricsModePush:
+        // the group is replaced on every push and outlives the process, a stable instance keeps one group per
+        // replica rather than one per run, holding the samples of the last push until the next run
+        instance := cfg.PushInstance
+        if instance == "" {
+            instance = hostname()
+        }
+        pusher := push.New(cfg.PushGatewayEndpoint, job).
+            Gatherer(prometheus.DefaultGatherer).
+            Grouping("instance", instance)
+        ticker := time.NewTicker(cfg.PushInterval)
+        stop, stopped := make(chan struct{}), make(chan struct{})
+        go func() {
+            defer close(stopped)
+            for {
+                select {
+                case <-ticker.C:
+                    pushMetrics(pusher)
+                case <-stop:
+                    return
+                }
+            }
+        }()
+        return func() {
+            ticker.Stop()
+            close(stop)
+            <-stopped
+            pushMetrics(pusher)
+        }
+    default:
+        Fatal(fmt.Sprintf("Unsupported METRICS_MODE %q, expected %q or %q", cfg.Mode, MetricsModeScrape, MetricsModePush))
+        return nil
+    }
+}
+
+// MetricsHandler serves the metrics registered with the default registry.
+func MetricsHandler() http.Handler {
+    return promhttp.Handler()
+}
+
+// ServeHTTP serves handler on addr in the background, for the workers that do not serve HTTP otherwise.
+func ServeHTTP(addr string, handler http.Handler) *http.Server {
+    server := &http.Server{Addr: addr, Handler: handler}
+    go func() {
+        if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+            slog.Error("Failed to serve HTTP", "addr", addr, ErrorAttr(err))
         }
-    }(metricName, value)
+    }()
+    return server
+}
+
+func pushMetrics(pusher *push.Pusher) {
+    if err := pusher.Push(); err != nil {
+        slog.Error("Could not push metrics to Push gateway", ErrorAttr(err))
+    }
+}
+
+// instanceName tells apart the processes of a service, in logs, traces and job events
+func instanceName() string {
+    return fmt.Sprintf("%s/%d", hostname(), os.Getpid())
+}
+
+func hostname() string {
+    hostname, err := os.Hostname()
+    if err != nil {
+        return "unknown"
+    }
+    return hostname
 }
@@ -0,0 +1,274 @@
+package common
+
+import (
+    "context"
+    "database/sql"
+    "embed"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "regexp"
+    "sort"
+    "strconv"
+    "time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey serialises concurrent migrators through pg_advisory_xact_lock
+const migrationLockKey = 727274
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+    Version int
+    Name    string
+    Up      string
+    Down    string
+}
+
+type MigrationStatus struct {
+    Migration
+    AppliedAt *time.Time
+}
+
+// LoadMigrations reads the embedded migrations ordered by version.
+func LoadMigrations() ([]Migration, error) {
+    return LoadMigrationsFrom(migrationFiles, "migrations")
+}
+
+// ExpectedSchemaVersion is the version the binary was built against, i.e. the latest embedded migration.
+func ExpectedSchemaVersion() (int, error) {
+    migrations, err := LoadMigrations()
+    if err != nil {
+        return 0, err
+    }
+    if len(migrations) == 0 {
+        return 0, nil
+    }
+    return migrations[len(migrations)-1].Version, nil
+}
+
+// LoadMigrationsFrom parses `<version>_<name>.<up|down>.sql` files from dir, requiring contiguous versions from 1.
+func LoadMigrationsFrom(fsys fs.FS, dir string) ([]Migration, error) {
+    entries, err := fs.ReadDir(fsys, dir)
+    if err != nil {
+        return nil, err
+    }
+
+    byVersion := map[int]*Migration{}
+    for _, entry := range entries {
+        match := migrationFileName.FindStringSubmatch(entry.Name())
+        if match == nil {
+            return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+        }
+        version, _ := strconv.Atoi(match[1])
+        content, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+        if err != nil {
+            return nil, err
+        }
+
+        m, ok := byVersion[version]
+        if !ok {
+            m = &Migration{Version: version, Name: match[2]}
+            byVersion[version] = m
+        }
+        if m.Name != match[2] {
+            return nil, fmt.Errorf("migration %d has conflicting names: %s and %s", version, m.Name, match[2])
+        }
+        if match[3] == "up" {
+            m.Up = string(content)
+        } else {
+            m.Down = string(content)
+        }
+    }
+
+    migrations := make([]Migration, 0, len(byVersion))
+    for _, m := range byVersion {
+        if m.Up == "" || m.Down == "" {
+            return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+        }
+        migrations = append(migrations, *m)
+    }
+    sort.Slice(migrations, func(i, j int) bool {
+        return migrations[i].Version < migrations[j].Version
+    })
+    for i, m := range migrations {
+        if m.Version != i+1 {
+            return nil, fmt.Errorf("migration versions must be contiguous from 1, found %d at position %d", m.Version, i+1)
+        }
+    }
+    return migrations, nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+    _, err := db.Exec(`
+     CREATE TABLE IF NOT EXISTS public.schema_migrations
+     (
+         version    INTEGER CONSTRAINT schema_migrations_pk PRIMARY KEY,
+         name       VARCHAR(255) NOT NULL,
+         applied_at TIMESTAMP DEFAULT NOW() NOT NULL
+     );
+    `)
+    return err
+}
+
+// CurrentSchemaVersion returns the highest applied migration, 0 on a fresh database.
+func CurrentSchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+    ctx, cancel := WithQueryTimeout(ctx)
+    defer cancel()
+    var exists bool
+    if err := db.QueryRowContext(ctx, `SELECT TO_REGCLASS('public.schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+        return 0, err
+    }
+    if !exists {
+        return 0, nil
+    }
+    var version int
+    err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+    return version, err
+}
+
+// RequireSchemaVersion fails when the database is behind the migrations embedded in the binary.
+func RequireSchemaVersion(ctx context.Context, db *sql.DB) error {
+    expected, err := ExpectedSchemaVersion()
+    if err != nil {
+        return err
+    }
+    current, err := CurrentSchemaVersion(ctx, db)
+    if err != nil {
+        return fmt.Errorf("error reading schema version: %v", err)
+    }
+    if current < expected {
+        return fmt.Errorf("database schema is at version %d, expected %d: run `go run migrate/app.go up`", current, expected)
+    }
+    return nil
+}
+
+// MigrateUp applies every pending migration, each in its own transaction.
+func MigrateUp(db *sql.DB) (int, error) {
+    migrations, err := LoadMigrations()
+    if err != nil {
+        return 0, err
+    }
+    if err = ensureMigrationsTable(db); err != nil {
+        return 0, err
+    }
+
+    applied := 0
+    for _, m := range migrations {
+        ok, err := runMigration(db, m, true)
+        if err != nil {
+            return applied, fmt.Errorf("migration %d_%s failed: %v", m.Version, m.Name, err)
+        }
+        if ok {
+            slog.Info("Applied migration", "version", m.Version, "name", m.Name)
+            applied++
+        }
+    }
+    return applied, nil
+}
+
+// MigrateDown rolls back the latest `steps` applied migrations.
+func MigrateDown(db *sql.DB, steps int) (int, error) {
+    migrations, err := LoadMigrations()
+    if err != nil {
+        return 0, err
+    }
+    if err = ensureMigrationsTable(db); err != nil {
+        return 0, err
+    }
+
+    reverted := 0
+    for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+        m := migrations[i]
+        ok, err := runMigration(db, m, false)
+        if err != nil {
+            return reverted, fmt.Errorf("rollback of %d_%s failed: %v", m.Version, m.Name, err)
+        }
+        if ok {
+            slog.Info("Reverted migration", "version", m.Version, "name", m.Name)
+            reverted++
+        }
+    }
+    return reverted, nil
+}
+
+// GetMigrationStatus lists every embedded migration with the time it was applied, if any.
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+    migrations, err := LoadMigrations()
+    if err != nil {
+        return nil, err
+    }
+    if err = ensureMigrationsTable(db); err != nil {
+        return nil, err
+    }
+
+    rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    appliedAt := map[int]time.Time{}
+    for rows.Next() {
+        var version int
+        var at time.Time
+        if err = rows.Scan(&version, &at); err != nil {
+            return nil, err
+        }
+        appliedAt[version] = at
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    statuses := make([]MigrationStatus, 0, len(migrations))
+    for _, m := range migrations {
+        status := MigrationStatus{Migration: m}
+        if at, ok := appliedAt[m.Version]; ok {
+            status.AppliedAt = &at
+        }
+        statuses = append(statuses, status)
+    }
+    return statuses, nil
+}
+
+// runMigration applies (up) or reverts (down) a single migration together with its schema_migrations record.
+// It returns false when there was nothing to do, e.g. another process applied it first.
+func runMigration(db *sql.DB, m Migration, up bool) (bool, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    if _, err = tx.Exec(`SELECT PG_ADVISORY_XACT_LOCK($1)`, migrationLockKey); err != nil {
+        return false, err
+    }
+
+    var applied bool
+    if err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, m.Version).Scan(&applied); err != nil {
+        return false, err
+    }
+    if applied == up {
+        return false, nil
+    }
+
+    if up {
+        if _, err = tx.Exec(m.Up); err != nil {
+            return false, err
+        }
+        _, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+    } else {
+        if _, err = tx.Exec(m.Down); err != nil {
+            return false, err
+        }
+        _, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+    }
+    if err != nil {
+        return false, err
+    }
+    return true, tx.Commit()
+}
@@ -0,0 +1,38 @@
+-- Baseline schema. IF NOT EXISTS keeps it compatible with databases provisioned by the old EnsureTable.
+CREATE TABLE IF NOT EXISTS public.jobs
+(
+    id        serial
+        CONSTRAINT jobs_pk
+            PRIMARY KEY,
+    due_at    timestamp DEFAULT NOW() NOT NULL,
+    priority  integer   DEFAULT 0,
+    tenant_id integer   DEFAULT 1,
+    status    integer   DEFAULT 0,
+    metadata  jsonb
+);
+
+-- tables created before metadata became JSONB keep their values as JSON strings
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1
+               FROM information_schema.columns
+               WHERE table_schema = 'public'
+                 AND table_name = 'jobs'
+                 AND column_name = 'metadata'
+                 AND data_type <> 'jsonb') THEN
+        ALTER TABLE public.jobs
+            ALTER COLUMN metadata TYPE jsonb USING TO_JSONB(metadata);
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS jobs_due_at_index
+    ON public.jobs (due_at);
+
+CREATE INDEX IF NOT EXISTS jobs_priority_index
+    ON public.jobs (priority);
+
+CREATE INDEX IF NOT EXISTS jobs_status_index
+    ON public.jobs (status);
+
+CREATE INDEX IF NOT EXISTS jobs_metadata_index
+    ON public.jobs USING gin (metadata jsonb_path_ops);
@@ -0,0 +1,42 @@
+ALTER TABLE public.jobs
+    RENAME TO jobs_partitioned;
+ALTER INDEX public.jobs_pk RENAME TO jobs_partitioned_pk;
+ALTER INDEX public.jobs_due_at_index RENAME TO jobs_partitioned_due_at_index;
+ALTER INDEX public.jobs_priority_index RENAME TO jobs_partitioned_priority_index;
+ALTER INDEX public.jobs_status_index RENAME TO jobs_partitioned_status_index;
+ALTER INDEX public.jobs_metadata_index RENAME TO jobs_partitioned_metadata_index;
+
+ALTER SEQUENCE public.jobs_id_seq OWNED BY NONE;
+
+CREATE TABLE public.jobs
+(
+    id        integer   DEFAULT NEXTVAL('public.jobs_id_seq') NOT NULL
+        CONSTRAINT jobs_pk
+            PRIMARY KEY,
+    due_at    timestamp DEFAULT NOW()                         NOT NULL,
+    priority  integer   DEFAULT 0,
+    tenant_id integer   DEFAULT 1,
+    status    integer   DEFAULT 0,
+    metadata  jsonb
+);
+
+ALTER SEQUENCE public.jobs_id_seq OWNED BY public.jobs.id;
+
+CREATE INDEX IF NOT EXISTS jobs_due_at_index
+    ON public.jobs (due_at);
+
+CREATE INDEX IF NOT EXISTS jobs_priority_index
+    ON public.jobs (priority);
+
+CREATE INDEX IF NOT EXISTS jobs_status_index
+    ON public.jobs (status);
+
+CREATE INDEX IF NOT EXISTS jobs_metadata_index
+    ON public.jobs USING gin (metadata jsonb_path_ops);
+
+INSERT INTO public.jobs (id, due_at, priority, tenant_id, status, metadata)
+SELECT id, due_at, priority, tenant_id, status, metadata
+FROM public.jobs_partitioned;
+
+-- drops every attached partition as well; detached partitions are left untouched
+DROP TABLE public.jobs_partitioned;
@@ -0,0 +1,59 @@
+-- Rebuild jobs as a table partitioned by due_at range. Daily partitions are named jobs_pYYYYMMDD and are
+-- created ahead of time by MaintainJobPartitions; rows outside every daily range land in jobs_default.
+ALTER TABLE public.jobs
+    RENAME TO jobs_unpartitioned;
+ALTER INDEX public.jobs_pk RENAME TO jobs_unpartitioned_pk;
+ALTER INDEX public.jobs_due_at_index RENAME TO jobs_unpartitioned_due_at_index;
+ALTER INDEX public.jobs_priority_index RENAME TO jobs_unpartitioned_priority_index;
+ALTER INDEX public.jobs_status_index RENAME TO jobs_unpartitioned_status_index;
+ALTER INDEX public.jobs_metadata_index RENAME TO jobs_unpartitioned_metadata_index;
+
+-- keep the id sequence alive when the old table is dropped
+ALTER SEQUENCE public.jobs_id_seq OWNED BY NONE;
+
+-- the partition key must be part of the primary key
+CREATE TABLE public.jobs
+(
+    id        integer   DEFAULT NEXTVAL('public.jobs_id_seq') NOT NULL,
+    due_at    timestamp DEFAULT NOW()                         NOT NULL,
+    priority  integer   DEFAULT 0,
+    tenant_id integer   DEFAULT 1,
+    status    integer   DEFAULT 0,
+    metadata  jsonb,
+    CONSTRAINT jobs_pk
+        PRIMARY KEY (id, due_at)
+) PARTITION BY RANGE (due_at);
+
+ALTER SEQUENCE public.jobs_id_seq OWNED BY public.jobs.id;
+
+CREATE INDEX IF NOT EXISTS jobs_due_at_index
+    ON public.jobs (due_at);
+
+CREATE INDEX IF NOT EXISTS jobs_priority_index
+    ON public.jobs (priority);
+
+CREATE INDEX IF NOT EXISTS jobs_status_index
+    ON public.jobs (status);
+
+CREATE INDEX IF NOT EXISTS jobs_metadata_index
+    ON public.jobs USING gin (metadata jsonb_path_ops);
+
+CREATE TABLE public.jobs_default PARTITION OF public.jobs DEFAULT;
+
+-- partitions for the coming week, before copying so existing rows are routed into them
+DO $$
+DECLARE
+    day date;
+BEGIN
+    FOR day IN SELECT GENERATE_SERIES(CURRENT_DATE, CURRENT_DATE + 7, INTERVAL '1 day')::date
+        LOOP
+            EXECUTE FORMAT('CREATE TABLE public.%I PARTITION OF public.jobs FOR VALUES FROM (%L) TO (%L)',
+                           'jobs_p' || TO_CHAR(day, 'YYYYMMDD'), day, day + 1);
+        END LOOP;
+END $$;
+
+INSERT INTO public.jobs (id, due_at, priority, tenant_id, status, metadata)
+SELECT id, due_at, priority, tenant_id, status, metadata
+FROM public.jobs_unpartitioned;
+
+DROP TABLE public.jobs_unpartitioned;
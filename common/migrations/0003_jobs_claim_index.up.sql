@@ -0,0 +1,5 @@
+-- Matches the due-job checker's claim query: status = 0 AND due_at <= NOW() ORDER BY priority.
+-- Only initialized jobs are indexed, so the index stays small while completed jobs pile up.
+CREATE INDEX IF NOT EXISTS jobs_claim_index
+    ON public.jobs (priority, due_at)
+    WHERE status = 0;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS public.consumer_deliveries;
+
+DROP TABLE IF EXISTS public.job_deliveries;
+
+ALTER TABLE public.jobs
+    DROP COLUMN IF EXISTS attempts,
+    DROP COLUMN IF EXISTS claimed_at,
+    DROP COLUMN IF EXISTS delivery_id;
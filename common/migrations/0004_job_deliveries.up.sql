@@ -0,0 +1,29 @@
+-- delivery_id stays the same across redeliveries of a job, consumers use it as their idempotency key
+ALTER TABLE public.jobs
+    ADD COLUMN delivery_id uuid    DEFAULT GEN_RANDOM_UUID() NOT NULL,
+    ADD COLUMN claimed_at  timestamp,
+    ADD COLUMN attempts    integer DEFAULT 0                 NOT NULL;
+
+-- written in the same transaction that marks the job completed
+CREATE TABLE IF NOT EXISTS public.job_deliveries
+(
+    delivery_id  uuid
+        CONSTRAINT job_deliveries_pk
+            PRIMARY KEY,
+    job_id       integer                 NOT NULL,
+    attempt      integer                 NOT NULL,
+    delivered_at timestamp DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS job_deliveries_delivered_at_index
+    ON public.job_deliveries (delivered_at);
+
+-- consumer side dedupe records, see common.ProcessDeliveryOnce
+CREATE TABLE IF NOT EXISTS public.consumer_deliveries
+(
+    consumer     varchar(100)            NOT NULL,
+    delivery_id  uuid                    NOT NULL,
+    processed_at timestamp DEFAULT NOW() NOT NULL,
+    CONSTRAINT consumer_deliveries_pk
+        PRIMARY KEY (consumer, delivery_id)
+);
@@ -0,0 +1,17 @@
+-- a job is claimable once every one of its dependencies is satisfied, see README "Job dependencies"
+CREATE TABLE IF NOT EXISTS public.job_dependencies
+(
+    job_id            integer                      NOT NULL,
+    depends_on_job_id integer                      NOT NULL,
+    on_failure        varchar(10) DEFAULT 'cancel' NOT NULL
+        CONSTRAINT job_dependencies_on_failure_check
+            CHECK (on_failure IN ('cancel', 'skip')),
+    satisfied         boolean     DEFAULT FALSE    NOT NULL,
+    CONSTRAINT job_dependencies_pk
+        PRIMARY KEY (job_id, depends_on_job_id)
+);
+
+-- completing a job marks the edges pointing at it satisfied
+CREATE INDEX IF NOT EXISTS job_dependencies_depends_on_index
+    ON public.job_dependencies (depends_on_job_id)
+    WHERE NOT satisfied;
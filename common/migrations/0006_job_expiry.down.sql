@@ -0,0 +1,2 @@
+ALTER TABLE public.jobs
+    DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,3 @@
+-- jobs still waiting after expires_at are expired instead of sent, NULL never expires
+ALTER TABLE public.jobs
+    ADD COLUMN expires_at timestamp;
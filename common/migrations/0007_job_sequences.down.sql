@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.jobs_sequence_index;
+
+ALTER TABLE public.jobs
+    DROP COLUMN IF EXISTS subscriber,
+    DROP COLUMN IF EXISTS sequence_id;
@@ -0,0 +1,7 @@
+-- the /schedule-job request and subscriber a job was created for, NULL for jobs scheduled before
+ALTER TABLE public.jobs
+    ADD COLUMN sequence_id uuid,
+    ADD COLUMN subscriber  integer;
+
+CREATE INDEX IF NOT EXISTS jobs_sequence_index
+    ON public.jobs (sequence_id, subscriber);
@@ -0,0 +1,21 @@
+-- audit trail of job status changes, written in the same statement or transaction as the change
+CREATE TABLE IF NOT EXISTS public.job_events
+(
+    id          bigserial
+        CONSTRAINT job_events_pk
+            PRIMARY KEY,
+    job_id      integer                 NOT NULL,
+    -- NULL when the job was created
+    from_status integer,
+    to_status   integer                 NOT NULL,
+    attempt     integer   DEFAULT 0     NOT NULL,
+    actor       varchar(100)            NOT NULL,
+    reason      text,
+    created_at  timestamp DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS job_events_job_id_index
+    ON public.job_events (job_id, id);
+
+CREATE INDEX IF NOT EXISTS job_events_created_at_index
+    ON public.job_events (created_at);
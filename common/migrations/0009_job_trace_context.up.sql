@@ -0,0 +1,3 @@
+-- W3C traceparent of the /schedule-job request that created the job, the dispatch span links back to it
+ALTER TABLE public.jobs
+    ADD COLUMN trace_parent varchar(55);
@@ -0,0 +1,3 @@
+-- type of the tenant the job was scheduled for, the due-job checker breaks lateness down by it
+ALTER TABLE public.jobs
+    ADD COLUMN tenant_type varchar(16);
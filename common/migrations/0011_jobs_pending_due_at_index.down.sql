@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.jobs_pending_due_at_index;
@@ -0,0 +1,5 @@
+-- Matches the due-job checker's sleep query: status = 0 ORDER BY due_at LIMIT 1. jobs_claim_index leads with
+-- priority and cannot hand out the earliest due_at, the polls would scan every partition's finished jobs otherwise.
+CREATE INDEX IF NOT EXISTS jobs_pending_due_at_index
+    ON public.jobs (due_at)
+    WHERE status = 0;
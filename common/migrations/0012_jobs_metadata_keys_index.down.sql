@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.jobs_metadata_keys_index;
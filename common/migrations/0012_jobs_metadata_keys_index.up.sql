@@ -0,0 +1,4 @@
+-- jobs_metadata_index uses jsonb_path_ops, which only serves @>. GET /jobs?metadata_key=... without a value filters
+-- on metadata ? key, which needs the default jsonb_ops operator class.
+CREATE INDEX IF NOT EXISTS jobs_metadata_keys_index
+    ON public.jobs USING gin (metadata);
@@ -0,0 +1,63 @@
+package common
+
+import (
+    "context"
+    "database/sql"
+    "github.com/lib/pq"
+    "time"
+)
+
+// JobsScheduledChannel is the Postgres NOTIFY channel announcing new due jobs,
+// the payload is the earliest due_at of the scheduled jobs in RFC3339 format.
+const JobsScheduledChannel = "jobs_scheduled"
+
+// NotifyFunc wakes up due-job checkers sleeping past dueAt, through the database or within the process.
+type NotifyFunc func(ctx context.Context, dueAt time.Time) error
+
+// NotifyJobsScheduled wakes up due-job checkers sleeping past dueAt.
+func NotifyJobsScheduled(ctx context.Context, db *sql.DB, dueAt time.Time) error {
+    ctx, cancel := WithQueryTimeout(ctx)
+    defer cancel()
+    _, err := db.ExecContext(ctx, `SELECT PG_NOTIFY($1, $2)`, JobsScheduledChannel, dueAt.UTC().Format(time.RFC3339Nano))
+    return err
+}
+
+// NotifyOn returns the NotifyFunc sending NotifyJobsScheduled on db.
+func NotifyOn(db *sql.DB) NotifyFunc {
+    return func(ctx context.Context, dueAt time.Time) error {
+        return NotifyJobsScheduled(ctx, db, dueAt)
+    }
+}
+
+// ParseJobsScheduledPayload reads the due_at sent by NotifyJobsScheduled.
+func ParseJobsScheduledPayload(payload string) (time.Time, error) {
+    return time.Parse(time.RFC3339Nano, payload)
+}
+
+// localNotificationsBuffer is the number of notifications kept for a checker busy claiming,
+// as the buffer of a pq.Listener
+const localNotificationsBuffer = 32
+
+// LocalNotifications delivers the notifications of the services of a single process, without a database.
+// They carry the payload of NotifyJobsScheduled, the due-job checker reads them as those of a pq.Listener.
+type LocalNotifications struct {
+    notifications chan *pq.Notification
+}
+
+func NewLocalNotifications() *LocalNotifications {
+    return &LocalNotifications{notifications: make(chan *pq.Notification, localNotificationsBuffer)}
+}
+
+// Notify never blocks, when the buffer is full the notification is dropped and the checker relies on polling.
+func (n *LocalNotifications) Notify(ctx context.Context, dueAt time.Time) error {
+    select {
+    case n.notifications <- &pq.Notification{Channel: JobsScheduledChannel, Extra: dueAt.UTC().Format(time.RFC3339Nano)}:
+    default:
+    }
+    return nil
+}
+
+// Listen returns the notifications for the due-job checker.
+func (n *LocalNotifications) Listen() <-chan *pq.Notification {
+    return n.notifications
+}
@@ -0,0 +1,198 @@
+package common
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "github.com/lib/pq"
+    "go-pg-bench/entity"
+    "log/slog"
+    "regexp"
+    "time"
+)
+
+const jobPartitionDateLayout = "20060102"
+
+var jobPartitionName = regexp.MustCompile(`^jobs_p(\d{8})$`)
+
+// JobPartitionName returns the name of the daily partition holding jobs due on the given day.
+func JobPartitionName(day time.Time) string {
+    return "jobs_p" + day.UTC().Format(jobPartitionDateLayout)
+}
+
+// ParseJobPartitionName returns the day covered by a daily partition, false for any other table.
+func ParseJobPartitionName(name string) (time.Time, bool) {
+    match := jobPartitionName.FindStringSubmatch(name)
+    if match == nil {
+        return time.Time{}, false
+    }
+    day, err := time.Parse(jobPartitionDateLayout, match[1])
+    if err != nil {
+        return time.Time{}, false
+    }
+    return day, true
+}
+
+// PartitionsToCreate lists the days in [today, today+daysAhead] that have no partition yet.
+func PartitionsToCreate(existing []time.Time, now time.Time, daysAhead int) []time.Time {
+    have := map[string]bool{}
+    for _, day := range existing {
+        have[JobPartitionName(day)] = true
+    }
+    today := truncateToDay(now)
+    var days []time.Time
+    for i := 0; i <= daysAhead; i++ {
+        day := today.AddDate(0, 0, i)
+        if !have[JobPartitionName(day)] {
+            days = append(days, day)
+        }
+    }
+    return days
+}
+
+// PartitionsToDetach lists the partitions whose whole range ended more than retentionDays ago.
+func PartitionsToDetach(existing []time.Time, now time.Time, retentionDays int) []time.Time {
+    cutoff := truncateToDay(now).AddDate(0, 0, -retentionDays)
+    var days []time.Time
+    for _, day := range existing {
+        if !day.AddDate(0, 0, 1).After(cutoff) {
+            days = append(days, day)
+        }
+    }
+    return days
+}
+
+// MaintainJobPartitions creates the daily partitions for the coming daysAhead days and detaches
+// partitions older than retentionDays once every job in them reached a final status.
+// Detached partitions are kept as plain tables so they can be archived or dropped by an operator.
+func MaintainJobPartitions(ctx context.Context, db *sql.DB, now time.Time, daysAhead int, retentionDays int) error {
+    existing, err := listJobPartitions(ctx, db)
+    if err != nil {
+        return fmt.Errorf("error listing job partitions: %v", err)
+    }
+
+    for _, day := range PartitionsToCreate(existing, now, daysAhead) {
+        if err = createJobPartition(ctx, db, day); err != nil {
+            return fmt.Errorf("error creating partition %s: %v", JobPartitionName(day), err)
+        }
+        slog.Info("Created job partition", "partition", JobPartitionName(day))
+    }
+
+    for _, day := range PartitionsToDetach(existing, now, retentionDays) {
+        detached, err := detachJobPartition(ctx, db, day)
+        if err != nil {
+            return fmt.Errorf("error detaching partition %s: %v", JobPartitionName(day), err)
+        }
+        if detached {
+            slog.Info("Detached job partition", "partition", JobPartitionName(day))
+        }
+    }
+    return nil
+}
+
+func listJobPartitions(ctx context.Context, db *sql.DB) ([]time.Time, error) {
+    ctx, cancel := WithQueryTimeout(ctx)
+    defer cancel()
+    rows, err := db.QueryContext(ctx, `
+      SELECT child.relname
+      FROM pg_inherits
+      JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+      JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+      WHERE parent.relname = 'jobs'`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var days []time.Time
+    for rows.Next() {
+        var name string
+        if err = rows.Scan(&name); err != nil {
+            return nil, err
+        }
+        if day, ok := ParseJobPartitionName(name); ok {
+            days = append(days, day)
+        }
+    }
+    return days, rows.Err()
+}
+
+// createJobPartition builds the partition next to the table, moves the rows of that day out of
+// jobs_default, then attaches it. CREATE ... PARTITION OF would fail when the default partition
+// already holds rows for the range, e.g. jobs scheduled far ahead with wait_specific_date.
+// LIKE copies the columns in the parent's order, which is also the order in jobs_default.
+func createJobPartition(ctx context.Context, db *sql.DB, day time.Time) error {
+    name := pq.QuoteIdentifier(JobPartitionName(day))
+    from, to := day, day.AddDate(0, 0, 1)
+
+    ctx, cancel := WithQueryTimeout(ctx)
+    defer cancel()
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    statements := []struct {
+        query string
+        args  []interface{}
+    }{
+        // block inserts into the default partition until the new range is attached
+        {query: `LOCK TABLE public.jobs_default IN SHARE ROW EXCLUSIVE MODE`},
+        {query: fmt.Sprintf(`CREATE TABLE public.%s (LIKE public.jobs INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`, name)},
+        {query: fmt.Sprintf(`
+          WITH moved AS (
+              DELETE FROM public.jobs_default
+              WHERE due_at >= $1 AND due_at < $2
+              RETURNING *
+          )
+          INSERT INTO public.%s
+          SELECT * FROM moved`, name), args: []interface{}{from, to}},
+        {query: fmt.Sprintf(`ALTER TABLE public.jobs ATTACH PARTITION public.%s FOR VALUES FROM ('%s') TO ('%s')`,
+            name, from.Format(time.DateOnly), to.Format(time.DateOnly))},
+    }
+    for _, s := range statements {
+        if _, err = tx.ExecContext(ctx, s.query, s.args...); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// detachJobPartition detaches the partition unless it still holds jobs waiting to be processed.
+// The check and the detach run under one lock on jobs: a requeue sets due_at = NOW() and could otherwise move a
+// job into the partition, or a claim change its status, between the two.
+func detachJobPartition(ctx context.Context, db *sql.DB, day time.Time) (bool, error) {
+    name := pq.QuoteIdentifier(JobPartitionName(day))
+
+    ctx, cancel := WithQueryTimeout(ctx)
+    defer cancel()
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    // DETACH PARTITION takes this lock on jobs anyway, taking it first keeps the lock order of every other query,
+    // jobs then its partitions
+    if _, err = tx.ExecContext(ctx, `LOCK TABLE public.jobs IN ACCESS EXCLUSIVE MODE`); err != nil {
+        return false, err
+    }
+    var pending bool
+    err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM public.%s WHERE status = ANY($1))`, name),
+        pq.Array([]int{int(entity.JobStatusInitialized), int(entity.JobStatusInProgress), int(entity.JobStatusFailed)}),
+    ).Scan(&pending)
+    if err != nil || pending {
+        return false, err
+    }
+
+    if _, err = tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE public.jobs DETACH PARTITION public.%s`, name)); err != nil {
+        return false, err
+    }
+    return true, tx.Commit()
+}
+
+func truncateToDay(t time.Time) time.Time {
+    t = t.UTC()
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+    "go-pg-bench/entity"
+    "time"
+)
+
+// PriorityAging lets overdue jobs move ahead of fresher ones. The claim order is ascending priority, the priority
+// of a job is the band of its tenant type, see entity.TenantType.Priority. A job's effective priority drops by one
+// level for every aging interval of its tenant type it spent past due_at.
+// A job of priority p waits at most about p intervals behind a constant stream of priority 0 jobs.
+type PriorityAging struct {
+    // Intervals holds the aging interval of each tenant type, a missing or zero interval disables aging for it.
+    // Priorities outside the tenant type bands are not aged.
+    Intervals map[entity.TenantType]time.Duration
+}
+
+// Interval returns the aging interval of the jobs of a priority band.
+func (a PriorityAging) Interval(priority int) time.Duration {
+    if priority < 0 || priority >= len(entity.TenantTypes) {
+        return 0
+    }
+    return a.Intervals[entity.TenantTypes[priority]]
+}
+
+// IntervalSeconds returns the interval of each band, indexed by priority, in the form the claim query takes them.
+func (a PriorityAging) IntervalSeconds() []int {
+    seconds := make([]int, 0, len(entity.TenantTypes))
+    for priority := range entity.TenantTypes {
+        seconds = append(seconds, int(a.Interval(priority)/time.Second))
+    }
+    return seconds
+}
+
+// EffectivePriority mirrors the ORDER BY of the due-job checker's claim query.
+func (a PriorityAging) EffectivePriority(priority int, overdue time.Duration) int {
+    interval := a.Interval(priority)
+    if interval < time.Second || overdue <= 0 {
+        return priority
+    }
+    return priority - int(overdue/interval)
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+    "context"
+    "log/slog"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+)
+
+// ShutdownContexts returns stop, cancelled on SIGINT or SIGTERM, and drain, cancelled timeout after stop.
+// Services stop taking new work when stop is done and give up on in-flight work when drain is done.
+// A second signal after the first one kills the process right away.
+func ShutdownContexts(timeout time.Duration) (stop context.Context, drain context.Context) {
+    stop, stopCancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    drain, drainCancel := context.WithCancel(context.Background())
+    go func() {
+        <-stop.Done()
+        stopCancel()
+        slog.Info("Gracefully shutting down, draining in-flight work", "timeout", timeout.String())
+        time.AfterFunc(timeout, drainCancel)
+    }()
+    return stop, drain
+}
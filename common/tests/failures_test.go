@@ -0,0 +1,129 @@
+package tests
+
+import (
+    "context"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "github.com/lib/pq"
+    "github.com/prometheus/client_golang/prometheus"
+    "go-pg-bench/common"
+    "testing"
+    "time"
+)
+
+func TestClassifyError(t *testing.T) {
+    tests := []struct {
+        name     string
+        err      error
+        expected common.ErrorClass
+    }{
+        {name: "Bad connection", err: driver.ErrBadConn, expected: common.ErrorClassTransient},
+        {name: "Wrapped bad connection", err: fmt.Errorf("claim: %w", driver.ErrBadConn), expected: common.ErrorClassTransient},
+        {name: "Serialization failure", err: &pq.Error{Code: "40001"}, expected: common.ErrorClassTransient},
+        {name: "Admin shutdown", err: &pq.Error{Code: "57P01"}, expected: common.ErrorClassTransient},
+        {name: "Too many connections", err: &pq.Error{Code: "53300"}, expected: common.ErrorClassTransient},
+        {name: "Undefined table", err: &pq.Error{Code: "42P01"}, expected: common.ErrorClassPermanent},
+        {name: "Unique violation", err: &pq.Error{Code: "23505"}, expected: common.ErrorClassPermanent},
+        {name: "Unknown error", err: errors.New("boom"), expected: common.ErrorClassPermanent},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := common.ClassifyError(tt.err); got != tt.expected {
+                t.Errorf("ClassifyError() = %s, want %s", got, tt.expected)
+            }
+        })
+    }
+}
+
+func TestFailureTracker(t *testing.T) {
+    policy := common.FailurePolicy{MaxConsecutiveFailures: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second}
+    tracker := common.NewFailureTracker(policy)
+
+    if backoff, fatal := tracker.Failure(driver.ErrBadConn); fatal != nil || backoff != 10*time.Millisecond {
+        t.Fatalf("first failure: backoff %v, fatal %v", backoff, fatal)
+    }
+    if backoff, fatal := tracker.Failure(driver.ErrBadConn); fatal != nil || backoff != 20*time.Millisecond {
+        t.Fatalf("second failure: backoff %v, fatal %v", backoff, fatal)
+    }
+
+    // a success resets the streak
+    tracker.Success()
+    if backoff, fatal := tracker.Failure(errors.New("boom")); fatal != nil || backoff != time.Second {
+        t.Fatalf("permanent failure: backoff %v, fatal %v", backoff, fatal)
+    }
+    tracker.Failure(driver.ErrBadConn)
+    if _, fatal := tracker.Failure(driver.ErrBadConn); fatal == nil {
+        t.Fatal("third consecutive failure did not reach the threshold")
+    }
+    if tracker.Transient != 4 || tracker.Permanent != 1 {
+        t.Errorf("counted %d transient and %d permanent errors, want 4 and 1", tracker.Transient, tracker.Permanent)
+    }
+}
+
+func TestFailureTrackerCountsErrorsByClass(t *testing.T) {
+    common.RegisterWorkerMetrics()
+    // registering again, as a second worker of the same process does, must not panic
+    common.RegisterWorkerMetrics()
+    before := workerErrors(t)
+    tracker := common.NewFailureTracker(common.FailurePolicy{})
+    tracker.Failure(driver.ErrBadConn)
+    tracker.Failure(driver.ErrBadConn)
+    tracker.Failure(errors.New("boom"))
+
+    after := workerErrors(t)
+    if got := after["transient"] - before["transient"]; got != 2 {
+        t.Errorf("worker_errors_total{class=\"transient\"} grew by %v, want 2", got)
+    }
+    if got := after["permanent"] - before["permanent"]; got != 1 {
+        t.Errorf("worker_errors_total{class=\"permanent\"} grew by %v, want 1", got)
+    }
+}
+
+// workerErrors reads worker_errors_total by class from the default registry
+func workerErrors(t *testing.T) map[string]float64 {
+    families, err := prometheus.DefaultGatherer.Gather()
+    if err != nil {
+        t.Fatal(err)
+    }
+    counts := map[string]float64{}
+    for _, family := range families {
+        if family.GetName() != "worker_errors_total" {
+            continue
+        }
+        for _, metric := range family.GetMetric() {
+            for _, label := range metric.GetLabel() {
+                if label.GetName() == "class" {
+                    counts[label.GetValue()] = metric.GetCounter().GetValue()
+                }
+            }
+        }
+    }
+    return counts
+}
+
+func TestRetryTransient(t *testing.T) {
+    policy := common.FailurePolicy{MaxConsecutiveFailures: 5, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+    attempts := 0
+    err := common.RetryTransient(context.Background(), policy, func() error {
+        attempts++
+        if attempts < 3 {
+            return driver.ErrBadConn
+        }
+        return nil
+    })
+    if err != nil || attempts != 3 {
+        t.Errorf("RetryTransient() = %v after %d attempts, want success after 3", err, attempts)
+    }
+
+    attempts = 0
+    err = common.RetryTransient(context.Background(), policy, func() error {
+        attempts++
+        return &pq.Error{Code: "42P01"}
+    })
+    if err == nil || attempts != 1 {
+        t.Errorf("RetryTransient() = %v after %d attempts, want the permanent error after 1", err, attempts)
+    }
+}
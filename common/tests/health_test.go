@@ -0,0 +1,58 @@
+package tests
+
+import (
+    "go-pg-bench/common"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestHealthCheckProgress(t *testing.T) {
+    health := common.NewHealth(nil, time.Minute)
+    now := time.Now()
+    if err := health.CheckProgress(now); err != nil {
+        t.Errorf("CheckProgress() right after NewHealth() error = %v", err)
+    }
+    if err := health.CheckProgress(now.Add(2 * time.Minute)); err == nil {
+        t.Error("CheckProgress() after 2 minutes without progress succeeded, want a stall error")
+    }
+
+    noLoop := common.NewHealth(nil, 0)
+    if err := noLoop.CheckProgress(now.Add(time.Hour)); err != nil {
+        t.Errorf("CheckProgress() without a worker loop error = %v", err)
+    }
+}
+
+func TestHealthzServesWithoutDatabase(t *testing.T) {
+    mux := http.NewServeMux()
+    common.NewHealth(nil, time.Minute).Register(mux)
+
+    recorder := httptest.NewRecorder()
+    mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+    if recorder.Code != http.StatusOK {
+        t.Errorf("GET /healthz = %d, want %d", recorder.Code, http.StatusOK)
+    }
+}
+
+func TestRegisterHealthReadyWhenEveryLoopIs(t *testing.T) {
+    progressing := common.NewHealth(nil, time.Minute)
+    stalled := common.NewHealth(nil, time.Nanosecond)
+    time.Sleep(time.Millisecond)
+
+    for _, c := range []struct {
+        healths []*common.Health
+        want    int
+    }{
+        {[]*common.Health{progressing}, http.StatusOK},
+        {[]*common.Health{progressing, stalled}, http.StatusServiceUnavailable},
+    } {
+        mux := http.NewServeMux()
+        common.RegisterHealth(mux, c.healths...)
+        recorder := httptest.NewRecorder()
+        mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+        if recorder.Code != c.want {
+            t.Errorf("GET /readyz with %d healths = %d, want %d", len(c.healths), recorder.Code, c.want)
+        }
+    }
+}
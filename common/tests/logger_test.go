@@ -0,0 +1,67 @@
+package tests
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "log/slog"
+    "testing"
+)
+
+func TestNewLoggerWritesJSONWithStandardFields(t *testing.T) {
+    var out bytes.Buffer
+    logger, err := common.NewLogger(&out, "due-job-checker", slog.LevelInfo, "")
+    if err != nil {
+        t.Fatalf("NewLogger() error = %v", err)
+    }
+
+    logger.Debug("Not written below the level")
+    job := entity.Job{Id: 42, TenantId: 7, SequenceId: "2f1c9a4e-8b7d-4c3e-9f60-1a2b3c4d5e6f"}
+    logger.Info("Failed to send job", append(common.JobAttrs(job), common.RequestIdAttr("req-1"))...)
+
+    var record map[string]interface{}
+    if err = json.Unmarshal(out.Bytes(), &record); err != nil {
+        t.Fatalf("log output %q is not a single JSON record: %v", out.String(), err)
+    }
+    want := map[string]interface{}{
+        "level":       "INFO",
+        "msg":         "Failed to send job",
+        "service":     "due-job-checker",
+        "job_id":      float64(42),
+        "tenant_id":   float64(7),
+        "sequence_id": job.SequenceId,
+        "request_id":  "req-1",
+    }
+    for key, value := range want {
+        if record[key] != value {
+            t.Errorf("record[%q] = %v, want %v", key, record[key], value)
+        }
+    }
+    if record["worker_id"] == "" || record["worker_id"] == nil {
+        t.Errorf("record has no worker_id: %v", record)
+    }
+}
+
+func TestNewLoggerRejectsUnknownFormat(t *testing.T) {
+    if _, err := common.NewLogger(&bytes.Buffer{}, "api-server", slog.LevelInfo, "xml"); err == nil {
+        t.Error("NewLogger() with format xml succeeded")
+    }
+}
+
+func TestJobAttrsLeavesOutUnsetFields(t *testing.T) {
+    if attrs := common.JobAttrs(entity.Job{Id: 1}); len(attrs) != 1 {
+        t.Errorf("JobAttrs() of a job without tenant and sequence = %v, want job_id only", attrs)
+    }
+}
+
+func TestLoggerFromContext(t *testing.T) {
+    if common.LoggerFrom(context.Background()) != slog.Default() {
+        t.Error("LoggerFrom() without a logger is not the default logger")
+    }
+    logger := slog.Default().With(common.RequestIdAttr("req-1"))
+    if common.LoggerFrom(common.ContextWithLogger(context.Background(), logger)) != logger {
+        t.Error("LoggerFrom() did not return the logger of the context")
+    }
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+    "go-pg-bench/common"
+    "go-pg-bench/config"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestPushMetricsStopOnFlush(t *testing.T) {
+    var mu sync.Mutex
+    var paths []string
+    gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        paths = append(paths, r.URL.Path)
+        mu.Unlock()
+        w.WriteHeader(http.StatusOK)
+    }))
+    defer gateway.Close()
+    pushes := func() []string {
+        mu.Lock()
+        defer mu.Unlock()
+        return append([]string(nil), paths...)
+    }
+
+    flush := common.StartMetrics("test_job", http.NewServeMux(), config.Metrics{
+        Mode:                common.MetricsModePush,
+        PushGatewayEndpoint: gateway.URL,
+        PushInterval:        10 * time.Millisecond,
+        PushInstance:        "runner-1",
+    })
+    deadline := time.Now().Add(5 * time.Second)
+    for len(pushes()) == 0 && time.Now().Before(deadline) {
+        time.Sleep(5 * time.Millisecond)
+    }
+    before := len(pushes())
+    if before == 0 {
+        t.Fatal("no push within 5s of StartMetrics()")
+    }
+
+    flush()
+    after := len(pushes())
+    if after <= before {
+        t.Errorf("flush() made no push, %d pushes before and after", after)
+    }
+    time.Sleep(50 * time.Millisecond)
+    if got := pushes(); len(got) != after {
+        t.Errorf("%d pushes after flush(), want the pushes to stop", len(got)-after)
+    }
+    for _, path := range pushes() {
+        if path != "/metrics/job/test_job/instance/runner-1" {
+            t.Errorf("pushed to %s, want the group of the job and the configured instance", path)
+        }
+    }
+}
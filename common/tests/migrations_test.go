@@ -0,0 +1,88 @@
+package tests
+
+import (
+    "go-pg-bench/common"
+    "testing"
+    "testing/fstest"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+    migrations, err := common.LoadMigrations()
+    if err != nil {
+        t.Fatalf("LoadMigrations() error = %v", err)
+    }
+    if len(migrations) == 0 {
+        t.Fatal("LoadMigrations() returned no migrations")
+    }
+
+    expected, err := common.ExpectedSchemaVersion()
+    if err != nil {
+        t.Fatalf("ExpectedSchemaVersion() error = %v", err)
+    }
+    if expected != migrations[len(migrations)-1].Version {
+        t.Errorf("ExpectedSchemaVersion() = %d, want %d", expected, migrations[len(migrations)-1].Version)
+    }
+}
+
+func TestLoadMigrationsFrom(t *testing.T) {
+    sql := &fstest.MapFile{Data: []byte("SELECT 1;")}
+    tests := []struct {
+        name      string
+        files     fstest.MapFS
+        expectErr bool
+    }{
+        {
+            name: "Ordered by version",
+            files: fstest.MapFS{
+                "m/0002_second.up.sql":   sql,
+                "m/0002_second.down.sql": sql,
+                "m/0001_first.up.sql":    sql,
+                "m/0001_first.down.sql":  sql,
+            },
+        },
+        {
+            name: "Missing down file",
+            files: fstest.MapFS{
+                "m/0001_first.up.sql": sql,
+            },
+            expectErr: true,
+        },
+        {
+            name: "Gap between versions",
+            files: fstest.MapFS{
+                "m/0001_first.up.sql":   sql,
+                "m/0001_first.down.sql": sql,
+                "m/0003_third.up.sql":   sql,
+                "m/0003_third.down.sql": sql,
+            },
+            expectErr: true,
+        },
+        {
+            name: "Invalid file name",
+            files: fstest.MapFS{
+                "m/first.sql": sql,
+            },
+            expectErr: true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            migrations, err := common.LoadMigrationsFrom(tt.files, "m")
+            if tt.expectErr {
+                if err == nil {
+                    t.Fatal("LoadMigrationsFrom() expected error, got nil")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("LoadMigrationsFrom() error = %v", err)
+            }
+            for i, m := range migrations {
+                if m.Version != i+1 {
+                    t.Errorf("migration %d has version %d", i, m.Version)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,32 @@
+package tests
+
+import (
+    "context"
+    "go-pg-bench/common"
+    "testing"
+    "time"
+)
+
+func TestLocalNotifications(t *testing.T) {
+    notifications := common.NewLocalNotifications()
+    dueAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+    if err := notifications.Notify(context.Background(), dueAt); err != nil {
+        t.Fatalf("Notify() error = %v", err)
+    }
+
+    notification := <-notifications.Listen()
+    if notification.Channel != common.JobsScheduledChannel {
+        t.Errorf("Channel = %q, want %q", notification.Channel, common.JobsScheduledChannel)
+    }
+    got, err := common.ParseJobsScheduledPayload(notification.Extra)
+    if err != nil || !got.Equal(dueAt) {
+        t.Errorf("ParseJobsScheduledPayload(%q) = %v, %v, want %v", notification.Extra, got, err, dueAt)
+    }
+
+    // a checker busy claiming does not block the notifier, it polls the jobs it missed
+    for i := 0; i < 100; i++ {
+        if err = notifications.Notify(context.Background(), dueAt); err != nil {
+            t.Fatalf("Notify() on a full buffer error = %v", err)
+        }
+    }
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+    "go-pg-bench/common"
+    "testing"
+    "time"
+)
+
+func TestJobPartitionName(t *testing.T) {
+    day := time.Date(2024, time.January, 5, 13, 30, 0, 0, time.UTC)
+    name := common.JobPartitionName(day)
+    if name != "jobs_p20240105" {
+        t.Fatalf("JobPartitionName() = %s, want jobs_p20240105", name)
+    }
+
+    parsed, ok := common.ParseJobPartitionName(name)
+    if !ok || !parsed.Equal(time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC)) {
+        t.Errorf("ParseJobPartitionName() = %v, %v", parsed, ok)
+    }
+
+    if _, ok = common.ParseJobPartitionName("jobs_default"); ok {
+        t.Error("ParseJobPartitionName() accepted jobs_default")
+    }
+}
+
+func TestPartitionsToCreate(t *testing.T) {
+    now := time.Date(2024, time.January, 5, 13, 30, 0, 0, time.UTC)
+    existing := []time.Time{
+        time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC),
+        time.Date(2024, time.January, 7, 0, 0, 0, 0, time.UTC),
+    }
+
+    got := common.PartitionsToCreate(existing, now, 3)
+    want := []string{"jobs_p20240106", "jobs_p20240108"}
+    if len(got) != len(want) {
+        t.Fatalf("PartitionsToCreate() returned %d days, want %d", len(got), len(want))
+    }
+    for i, day := range got {
+        if common.JobPartitionName(day) != want[i] {
+            t.Errorf("PartitionsToCreate()[%d] = %s, want %s", i, common.JobPartitionName(day), want[i])
+        }
+    }
+}
+
+func TestPartitionsToDetach(t *testing.T) {
+    now := time.Date(2024, time.January, 31, 13, 30, 0, 0, time.UTC)
+    existing := []time.Time{
+        time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),  // ended Jan 2, older than the cutoff
+        time.Date(2024, time.January, 30, 0, 0, 0, 0, time.UTC), // still within retention
+        time.Date(2024, time.January, 20, 0, 0, 0, 0, time.UTC), // ends exactly at the cutoff
+    }
+
+    got := common.PartitionsToDetach(existing, now, 10)
+    if len(got) != 2 {
+        t.Fatalf("PartitionsToDetach() returned %d days, want 2", len(got))
+    }
+    if common.JobPartitionName(got[0]) != "jobs_p20240101" || common.JobPartitionName(got[1]) != "jobs_p20240120" {
+        t.Errorf("PartitionsToDetach() = %v", got)
+    }
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestIntervalSeconds(t *testing.T) {
+    aging := common.PriorityAging{Intervals: map[entity.TenantType]time.Duration{
+        entity.TenantTypeNew: time.Minute,
+        entity.TenantTypeSme: 30 * time.Second,
+    }}
+    if !reflect.DeepEqual(aging.IntervalSeconds(), []int{60, 30, 0}) {
+        t.Errorf("IntervalSeconds() = %v, want [60 30 0]", aging.IntervalSeconds())
+    }
+}
+
+func TestEffectivePriority(t *testing.T) {
+    aging := common.PriorityAging{Intervals: map[entity.TenantType]time.Duration{
+        entity.TenantTypeNew:        time.Minute,
+        entity.TenantTypeSme:        30 * time.Second,
+        entity.TenantTypeEnterprise: 0,
+    }}
+
+    tests := []struct {
+        name     string
+        priority int
+        overdue  time.Duration
+        expected int
+    }{
+        {name: "Not overdue", priority: 1, overdue: 0, expected: 1},
+        {name: "Less than one interval", priority: 1, overdue: 29 * time.Second, expected: 1},
+        {name: "One level per interval of the tenant type", priority: 1, overdue: 90 * time.Second, expected: -2},
+        {name: "Keeps aging past priority 0", priority: 0, overdue: 2 * time.Minute, expected: -2},
+        {name: "Aging disabled for the tenant type", priority: 2, overdue: time.Hour, expected: 2},
+        {name: "Outside the tenant type bands", priority: 5, overdue: time.Hour, expected: 5},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := aging.EffectivePriority(tt.priority, tt.overdue); got != tt.expected {
+                t.Errorf("EffectivePriority() = %d, want %d", got, tt.expected)
+            }
+        })
+    }
+}
+
+func TestTenantTypePriority(t *testing.T) {
+    for tenantType, expected := range map[entity.TenantType]int{
+        entity.TenantTypeNew:        0,
+        entity.TenantTypeSme:        1,
+        entity.TenantTypeEnterprise: 2,
+        "":                          0,
+    } {
+        if got := tenantType.Priority(); got != expected {
+            t.Errorf("TenantType(%q).Priority() = %d, want %d", tenantType, got, expected)
+        }
+    }
+}
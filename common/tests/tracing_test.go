@@ -0,0 +1,36 @@
+package tests
+
+import (
+    "context"
+    "go-pg-bench/common"
+    "go.opentelemetry.io/otel/trace"
+    "testing"
+)
+
+func TestTraceParentRoundTrip(t *testing.T) {
+    spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+        TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+        SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+        TraceFlags: trace.FlagsSampled,
+    })
+    ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+
+    traceParent := common.TraceParent(ctx)
+    if traceParent != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+        t.Fatalf("TraceParent() = %q", traceParent)
+    }
+    if parsed := common.SpanContextOf(traceParent); !parsed.Equal(spanContext.WithRemote(true)) {
+        t.Errorf("SpanContextOf(%q) = %v, want %v", traceParent, parsed, spanContext)
+    }
+}
+
+func TestTraceParentWithoutSpan(t *testing.T) {
+    if traceParent := common.TraceParent(context.Background()); traceParent != "" {
+        t.Errorf("TraceParent() without a span = %q, want empty", traceParent)
+    }
+    for _, traceParent := range []string{"", "not-a-trace-parent"} {
+        if common.SpanContextOf(traceParent).IsValid() {
+            t.Errorf("SpanContextOf(%q) is valid", traceParent)
+        }
+    }
+}
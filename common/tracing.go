@@ -0,0 +1,155 @@
+package common
+
+import (
+    "context"
+    "fmt"
+    "go-pg-bench/config"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+    "go.opentelemetry.io/otel/trace"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+const (
+    // TracesExporterNone records no spans, the default. Trace context is still propagated.
+    TracesExporterNone = config.TracesExporterNone
+    // TracesExporterOTLP sends the spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT, e.g. a local collector
+    TracesExporterOTLP = config.TracesExporterOTLP
+    // TracesExporterFile appends the spans as JSON to TRACES_FILE, for tests
+    TracesExporterFile = config.TracesExporterFile
+)
+
+// tracerName is the instrumentation scope of the spans of every service
+const tracerName = "go-pg-bench"
+
+// traceContext is the W3C format of the trace context stored with the jobs and passed along in job messages
+var traceContext = propagation.TraceContext{}
+
+// StartTracing installs the global tracer provider according to the traces exporter, with service as service.name.
+// The returned shutdown flushes the spans still buffered, services call it before exiting.
+func StartTracing(service string, cfg config.Tracing) (shutdown func()) {
+    otel.SetTextMapPropagator(traceContext)
+
+    var exporter sdktrace.SpanExporter
+    var err error
+    switch cfg.Exporter {
+    case "", TracesExporterNone:
+        return func() {}
+    case TracesExporterOTLP:
+        exporter, err = newOTLPExporter(cfg.OTLPEndpoint)
+    case TracesExporterFile:
+        exporter, err = newFileExporter(cfg.File)
+    default:
+        Fatal(fmt.Sprintf("Unsupported TRACES_EXPORTER %q, expected %q, %q or %q", cfg.Exporter, TracesExporterNone, TracesExporterOTLP, TracesExporterFile))
+    }
+    if err != nil {
+        Fatal("Failed to create the traces exporter", ErrorAttr(err))
+    }
+
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+            semconv.ServiceName(service),
+            semconv.ServiceInstanceID(instanceName()),
+        )),
+    )
+    otel.SetTracerProvider(provider)
+    return func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := provider.Shutdown(ctx); err != nil {
+            slog.Error("Failed to flush traces", ErrorAttr(err))
+        }
+    }
+}
+
+// Tracer returns the tracer of the global tracer provider, a no-op one until StartTracing installs it.
+func Tracer() trace.Tracer {
+    return otel.Tracer(tracerName)
+}
+
+// StartServerSpan starts the span of an incoming HTTP request, as a child of the caller's traceparent header if any.
+func StartServerSpan(r *http.Request, name string) (context.Context, trace.Span) {
+    ctx := traceContext.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+    return Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// EndSpan marks span as failed when err is not nil and ends it.
+func EndSpan(span trace.Span, err error) {
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx, empty when ctx holds no valid span.
+func TraceParent(ctx context.Context) string {
+    carrier := propagation.MapCarrier{}
+    traceContext.Inject(ctx, carrier)
+    return carrier.Get("traceparent")
+}
+
+// SpanContextOf parses a traceparent returned by TraceParent, the span context is invalid when it is empty or malformed.
+func SpanContextOf(traceParent string) trace.SpanContext {
+    ctx := traceContext.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceParent})
+    return trace.SpanContextFromContext(ctx)
+}
+
+// newOTLPExporter sends the spans to the /v1/traces path of endpoint, like the OTEL_EXPORTER_OTLP_ENDPOINT
+// environment variable of the OpenTelemetry SDKs
+func newOTLPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+    u, err := url.Parse(endpoint)
+    if err != nil {
+        return nil, err
+    }
+    options := []otlptracehttp.Option{
+        otlptracehttp.WithEndpoint(u.Host),
+        otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+    }
+    if u.Scheme == "http" {
+        options = append(options, otlptracehttp.WithInsecure())
+    }
+    return otlptracehttp.New(context.Background(), options...)
+}
+
+// fileExporter closes the file the spans are written to when the tracer provider shuts down
+type fileExporter struct {
+    *stdouttrace.Exporter
+    file *os.File
+}
+
+func newFileExporter(path string) (sdktrace.SpanExporter, error) {
+    if path == "" {
+        path = "traces.json"
+    }
+    file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        return nil, err
+    }
+    exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    return fileExporter{Exporter: exporter, file: file}, nil
+}
+
+func (e fileExporter) Shutdown(ctx context.Context) error {
+    err := e.Exporter.Shutdown(ctx)
+    if closeErr := e.file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
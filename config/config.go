@@ -0,0 +1,307 @@
+// Package config loads the typed configuration of each service. Every setting has an environment variable name,
+// a flag derived from it and a default; the values are read from the defaults, an optional config file of
+// KEY=value lines, the environment and the command line flags, each one overriding the previous.
+package config
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "github.com/joho/godotenv"
+    "io"
+    "net/url"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// DefaultConfigFile is looked up in the working directory and its parents when no --config is given
+const DefaultConfigFile = "local.env"
+
+// Config is the configuration of a service: a struct of settings and sections of settings.
+//
+// A setting is a field tagged with its environment variable, `env:"NAME"`, and optionally with
+// `default:"value"`, `required:"true"`, `secret:"true"` (redacted by Print), `min:"n"`, `oneof:"a,b"`,
+// `unit:"ms"` or `unit:"s"` for durations, and `usage:"..."` for the flag help.
+// Supported types are string, int, bool, time.Duration and []time.Duration (comma separated).
+// Validate checks what the tags cannot express, it runs once every setting is valid on its own.
+type Config interface {
+    Validate() error
+}
+
+// MustLoad loads cfg from the command line, exiting with the list of errors when it is invalid.
+// With --print-config it prints the loaded configuration with secrets redacted and exits.
+func MustLoad(cfg Config) {
+    printConfig, err := Load(cfg, flag.CommandLine, os.Args[1:])
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+        os.Exit(2)
+    }
+    if printConfig {
+        Print(os.Stdout, cfg)
+        os.Exit(0)
+    }
+}
+
+// Load registers a flag per setting of cfg on flags, parses args and fills cfg.
+// Arguments left after the flags stay available through flags.Args().
+func Load(cfg Config, flags *flag.FlagSet, args []string) (printConfig bool, err error) {
+    settings := settingsOf(cfg)
+    for _, s := range settings {
+        if value, ok := s.tag.Lookup("default"); ok {
+            if err := s.set(value); err != nil {
+                panic(fmt.Sprintf("invalid default of %s: %v", s.key, err))
+            }
+        }
+    }
+
+    configFile := flags.String("config", "", "file of KEY=value settings, "+DefaultConfigFile+" of the working directory or a parent by default")
+    printConfigFlag := flags.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+    for _, s := range settings {
+        flags.String(s.flag(), "", s.usage())
+    }
+    if err := flags.Parse(args); err != nil {
+        return false, err
+    }
+
+    file, err := readConfigFile(*configFile)
+    if err != nil {
+        return false, err
+    }
+    flagValues := map[string]string{}
+    flags.Visit(func(f *flag.Flag) {
+        flagValues[f.Name] = f.Value.String()
+    })
+
+    var errs []error
+    for _, s := range settings {
+        value, source, found := lookup(s, flagValues, file)
+        if !found {
+            continue
+        }
+        if err := s.set(value); err != nil {
+            errs = append(errs, fmt.Errorf("%s (from %s): %w", s.key, source, err))
+        }
+    }
+    for _, s := range settings {
+        if err := s.validate(); err != nil {
+            errs = append(errs, fmt.Errorf("%s: %w", s.key, err))
+        }
+    }
+    if len(errs) == 0 {
+        errs = append(errs, cfg.Validate())
+    }
+    return *printConfigFlag, errors.Join(errs...)
+}
+
+// Print writes cfg as KEY=value lines that Load reads back, with secrets redacted.
+func Print(w io.Writer, cfg Config) {
+    for _, s := range settingsOf(cfg) {
+        value := s.String()
+        if s.tag.Get("secret") == "true" {
+            value = redact(value)
+        }
+        fmt.Fprintf(w, "%s=%s\n", s.key, value)
+    }
+}
+
+// lookup returns the value of s from the flags, the environment or the config file, in this order
+func lookup(s setting, flagValues map[string]string, file map[string]string) (value string, source string, found bool) {
+    if value, found = flagValues[s.flag()]; found {
+        return value, "--" + s.flag(), true
+    }
+    if value, found = os.LookupEnv(s.key); found {
+        return value, "environment", true
+    }
+    if value, found = file[s.key]; found {
+        return value, "config file", true
+    }
+    return "", "", false
+}
+
+// readConfigFile reads path, or the default config file if there is one when path is empty
+func readConfigFile(path string) (map[string]string, error) {
+    if path == "" {
+        if path = findDefaultConfigFile(); path == "" {
+            return nil, nil
+        }
+    }
+    values, err := godotenv.Read(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+    }
+    return values, nil
+}
+
+func findDefaultConfigFile() string {
+    dir, err := os.Getwd()
+    if err != nil {
+        return ""
+    }
+    for {
+        path := filepath.Join(dir, DefaultConfigFile)
+        if _, err := os.Stat(path); err == nil {
+            return path
+        }
+        parent := filepath.Dir(dir)
+        if parent == dir {
+            return ""
+        }
+        dir = parent
+    }
+}
+
+// redact hides the password of a URL, or the whole value otherwise
+func redact(value string) string {
+    if value == "" {
+        return ""
+    }
+    if u, err := url.Parse(value); err == nil && u.User != nil {
+        if _, hasPassword := u.User.Password(); hasPassword {
+            return u.Redacted()
+        }
+    }
+    return "[REDACTED]"
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is a tagged field of a Config
+type setting struct {
+    key   string
+    tag   reflect.StructTag
+    value reflect.Value
+}
+
+func settingsOf(cfg Config) []setting {
+    v := reflect.ValueOf(cfg)
+    if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+        panic(fmt.Sprintf("config: %T is not a pointer to a struct", cfg))
+    }
+    return collectSettings(v.Elem(), nil)
+}
+
+// collectSettings walks the fields of v in order, descending into the sections
+func collectSettings(v reflect.Value, settings []setting) []setting {
+    for i := 0; i < v.NumField(); i++ {
+        field := v.Type().Field(i)
+        if key, ok := field.Tag.Lookup("env"); ok {
+            settings = append(settings, setting{key: key, tag: field.Tag, value: v.Field(i)})
+        } else if field.Type.Kind() == reflect.Struct {
+            settings = collectSettings(v.Field(i), settings)
+        }
+    }
+    return settings
+}
+
+// flag is the key in lower case with dashes, e.g. --job-max-attempts for JOB_MAX_ATTEMPTS
+func (s setting) flag() string {
+    return strings.ReplaceAll(strings.ToLower(s.key), "_", "-")
+}
+
+func (s setting) usage() string {
+    usage := s.tag.Get("usage")
+    if usage == "" {
+        usage = s.key
+    }
+    if unit := s.tag.Get("unit"); unit != "" {
+        usage += " (" + unit + ")"
+    }
+    if oneOf := s.tag.Get("oneof"); oneOf != "" {
+        usage += ", one of " + oneOf
+    }
+    if value, ok := s.tag.Lookup("default"); ok && value != "" {
+        usage += ", default " + value
+    }
+    return usage
+}
+
+func (s setting) unit() time.Duration {
+    switch s.tag.Get("unit") {
+    case "ms":
+        return time.Millisecond
+    case "s":
+        return time.Second
+    default:
+        panic(fmt.Sprintf("config: %s needs a unit tag of ms or s", s.key))
+    }
+}
+
+func (s setting) set(raw string) error {
+    raw = strings.TrimSpace(raw)
+    switch {
+    case s.value.Type() == durationType:
+        d, err := s.parseDuration(raw)
+        if err != nil {
+            return err
+        }
+        s.value.SetInt(int64(d))
+    case s.value.Kind() == reflect.String:
+        s.value.SetString(raw)
+    case s.value.Kind() == reflect.Int:
+        n, err := strconv.Atoi(raw)
+        if err != nil {
+            return fmt.Errorf("invalid integer %q", raw)
+        }
+        s.value.SetInt(int64(n))
+    case s.value.Kind() == reflect.Bool:
+        b, err := strconv.ParseBool(raw)
+        if err != nil {
+            return fmt.Errorf("invalid boolean %q", raw)
+        }
+        s.value.SetBool(b)
+    default:
+        panic(fmt.Sprintf("config: unsupported type %s of %s", s.value.Type(), s.key))
+    }
+    return nil
+}
+
+func (s setting) parseDuration(raw string) (time.Duration, error) {
+    n, err := strconv.Atoi(raw)
+    if err != nil {
+        return 0, fmt.Errorf("invalid integer number of %s %q", s.tag.Get("unit"), raw)
+    }
+    return time.Duration(n) * s.unit(), nil
+}
+
+// String formats the value the way set parses it
+func (s setting) String() string {
+    switch {
+    case s.value.Type() == durationType:
+        return strconv.FormatInt(s.value.Int()/int64(s.unit()), 10)
+    default:
+        return fmt.Sprint(s.value.Interface())
+    }
+}
+
+// validate checks the required, min and oneof tags
+func (s setting) validate() error {
+    if s.tag.Get("required") == "true" && s.value.IsZero() {
+        return errors.New("is required")
+    }
+    if oneOf, ok := s.tag.Lookup("oneof"); ok {
+        for _, allowed := range strings.Split(oneOf, ",") {
+            if s.value.String() == allowed {
+                return nil
+            }
+        }
+        return fmt.Errorf("must be one of %s, got %q", oneOf, s.value.String())
+    }
+    if rawMin, ok := s.tag.Lookup("min"); ok {
+        minimum, err := strconv.ParseInt(rawMin, 10, 64)
+        if err != nil {
+            panic(fmt.Sprintf("config: invalid min tag of %s", s.key))
+        }
+        n := s.value.Int()
+        if s.value.Type() == durationType {
+            n /= int64(s.unit())
+        }
+        if n < minimum {
+            return fmt.Errorf("must be at least %d, got %d", minimum, n)
+        }
+    }
+    return nil
+}
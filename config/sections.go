@@ -0,0 +1,122 @@
+package config
+
+import (
+    "errors"
+    "fmt"
+    "go-pg-bench/entity"
+    "net/url"
+    "time"
+)
+
+// Sections shared by the services
+
+type Database struct {
+    ConnectionString string        `env:"POSTGRES_CONNECTION_STRING" required:"true" secret:"true" usage:"Postgres connection URL"`
+    MaxOpenConns     int           `env:"DB_MAX_OPEN_CONNS" default:"20" min:"0" usage:"connections open at once, 0 is unlimited"`
+    MaxIdleConns     int           `env:"DB_MAX_IDLE_CONNS" default:"10" min:"0" usage:"idle connections kept in the pool"`
+    ConnMaxLifetime  time.Duration `env:"DB_CONN_MAX_LIFETIME_IN_SECONDS" default:"1800" unit:"s" min:"0" usage:"age after which a connection is closed, 0 keeps it"`
+    ConnMaxIdleTime  time.Duration `env:"DB_CONN_MAX_IDLE_TIME_IN_SECONDS" default:"300" unit:"s" min:"0" usage:"idle time after which a connection is closed, 0 keeps it"`
+    // StatementTimeout is the statement_timeout of the sessions, Postgres cancels the statements running longer
+    StatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT_MS" default:"30000" unit:"ms" min:"0" usage:"statement_timeout of the sessions, 0 disables it"`
+    // QueryTimeout is the deadline of the context of each query, it also covers an unresponsive server
+    QueryTimeout          time.Duration `env:"DB_QUERY_TIMEOUT_MS" default:"35000" unit:"ms" min:"0" usage:"deadline of each query or transaction, 0 disables it"`
+    ConnectAttempts       int           `env:"DB_CONNECT_MAX_ATTEMPTS" default:"10" min:"1" usage:"pings before a service gives up connecting at startup"`
+    ConnectInitialBackoff time.Duration `env:"DB_CONNECT_INITIAL_BACKOFF_MS" default:"200" unit:"ms" min:"1"`
+    ConnectMaxBackoff     time.Duration `env:"DB_CONNECT_MAX_BACKOFF_MS" default:"5000" unit:"ms" min:"1"`
+}
+
+func (d Database) Validate() error {
+    var errs []error
+    if d.StatementTimeout > 0 && d.QueryTimeout > 0 && d.QueryTimeout < d.StatementTimeout {
+        errs = append(errs, errors.New("DB_QUERY_TIMEOUT_MS must not be below DB_STATEMENT_TIMEOUT_MS, Postgres should cancel first and report it"))
+    }
+    if d.ConnectInitialBackoff > d.ConnectMaxBackoff {
+        errs = append(errs, errors.New("DB_CONNECT_INITIAL_BACKOFF_MS must not exceed DB_CONNECT_MAX_BACKOFF_MS"))
+    }
+    return errors.Join(errs...)
+}
+
+const (
+    MetricsModeScrape = "scrape"
+    MetricsModePush   = "push"
+)
+
+type Metrics struct {
+    Mode                string        `env:"METRICS_MODE" default:"scrape" oneof:"scrape,push" usage:"serve /metrics for Prometheus to scrape, or push to the Pushgateway"`
+    PushGatewayEndpoint string        `env:"PUSH_GATEWAY_ENDPOINT" usage:"Pushgateway URL of the push mode"`
+    PushInterval        time.Duration `env:"METRICS_PUSH_INTERVAL_MS" default:"5000" unit:"ms" min:"1" usage:"interval between pushes"`
+    PushInstance        string        `env:"METRICS_PUSH_INSTANCE" usage:"instance label of the pushed metrics, the hostname when empty"`
+}
+
+func (m Metrics) Validate() error {
+    if m.Mode == MetricsModePush && m.PushGatewayEndpoint == "" {
+        return errors.New("PUSH_GATEWAY_ENDPOINT is required with METRICS_MODE=push")
+    }
+    return nil
+}
+
+const (
+    TracesExporterNone = "none"
+    TracesExporterOTLP = "otlp"
+    TracesExporterFile = "file"
+)
+
+type Tracing struct {
+    Exporter     string `env:"TRACES_EXPORTER" default:"none" oneof:"none,otlp,file" usage:"where spans are exported"`
+    File         string `env:"TRACES_FILE" default:"traces.json" usage:"file the spans are appended to with TRACES_EXPORTER=file"`
+    OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"http://localhost:4318" usage:"OTLP/HTTP collector URL"`
+}
+
+func (t Tracing) Validate() error {
+    if t.Exporter != TracesExporterOTLP {
+        return nil
+    }
+    u, err := url.Parse(t.OTLPEndpoint)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT must be an http(s) URL, got %q", t.OTLPEndpoint)
+    }
+    return nil
+}
+
+type Logging struct {
+    Level  string `env:"LOG_LEVEL" default:"info" oneof:"debug,info,warn,error"`
+    Format string `env:"LOG_FORMAT" default:"json" oneof:"json,text"`
+}
+
+type Shutdown struct {
+    Timeout time.Duration `env:"SHUTDOWN_TIMEOUT_IN_SECONDS" default:"30" unit:"s" min:"0" usage:"how long in-flight work is drained on SIGTERM"`
+}
+
+// Retry bounds the retries of the worker loops
+type Retry struct {
+    MaxConsecutiveFailures int           `env:"WORKER_MAX_CONSECUTIVE_FAILURES" default:"10" min:"0" usage:"failures in a row before a worker exits, 0 never exits"`
+    InitialBackoff         time.Duration `env:"WORKER_RETRY_INITIAL_BACKOFF_MS" default:"100" unit:"ms" min:"1"`
+    MaxBackoff             time.Duration `env:"WORKER_RETRY_MAX_BACKOFF_MS" default:"10000" unit:"ms" min:"1"`
+}
+
+func (r Retry) Validate() error {
+    if r.InitialBackoff > r.MaxBackoff {
+        return errors.New("WORKER_RETRY_INITIAL_BACKOFF_MS must not exceed WORKER_RETRY_MAX_BACKOFF_MS")
+    }
+    return nil
+}
+
+type Health struct {
+    MaxStall time.Duration `env:"HEALTH_MAX_STALL_IN_SECONDS" default:"60" unit:"s" min:"1" usage:"time without worker loop progress before /readyz fails"`
+}
+
+// PriorityAging holds the aging interval of the jobs of each tenant type, see common.PriorityAging
+type PriorityAging struct {
+    New        time.Duration `env:"JOB_PRIORITY_AGING_NEW_IN_SECONDS" default:"60" unit:"s" min:"0" usage:"aging interval of the jobs of new tenants and of those without a tenant type, 0 disables it"`
+    Sme        time.Duration `env:"JOB_PRIORITY_AGING_SME_IN_SECONDS" default:"60" unit:"s" min:"0" usage:"aging interval of the jobs of sme tenants, 0 disables it"`
+    Enterprise time.Duration `env:"JOB_PRIORITY_AGING_ENTERPRISE_IN_SECONDS" default:"60" unit:"s" min:"0" usage:"aging interval of the jobs of enterprise tenants, 0 disables it"`
+}
+
+// Intervals returns the intervals by tenant type.
+func (a PriorityAging) Intervals() map[entity.TenantType]time.Duration {
+    return map[entity.TenantType]time.Duration{
+        entity.TenantTypeNew:        a.New,
+        entity.TenantTypeSme:        a.Sme,
+        entity.TenantTypeEnterprise: a.Enterprise,
+    }
+}
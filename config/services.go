@@ -0,0 +1,130 @@
+package config
+
+import (
+    "errors"
+    "time"
+)
+
+type APIServer struct {
+    Database Database
+    Metrics  Metrics
+    Tracing  Tracing
+    Logging  Logging
+    Shutdown Shutdown
+
+    Addr             string `env:"API_SERVER_ADDR" default:":8081" usage:"address the API is served on"`
+    MetadataMaxBytes int    `env:"JOB_METADATA_MAX_BYTES" default:"4096" min:"1" usage:"size limit of the metadata of a job"`
+    // BatchParameters bounds the parameters of one insert, Postgres supports up to 65535
+    BatchParameters int `env:"POSTGRES_SUPPORTED_BATCH_PARAMETERS" default:"65535" min:"1" usage:"parameters per insert statement"`
+}
+
+func (c *APIServer) Validate() error {
+    return errors.Join(c.Database.Validate(), c.Metrics.Validate(), c.Tracing.Validate())
+}
+
+type DueJobChecker struct {
+    Database Database
+    Metrics  Metrics
+    Tracing  Tracing
+    Logging  Logging
+    Shutdown Shutdown
+    Retry    Retry
+    Health   Health
+    Aging    PriorityAging
+
+    HTTPAddr            string        `env:"DUE_JOB_CHECKER_HTTP_ADDR" default:":9101" usage:"address of /metrics, /healthz and /readyz, unserved when set empty"`
+    BatchSize           int           `env:"DUE_JOB_CHECKER_BATCH_SIZE" default:"1000" min:"1" usage:"jobs claimed at once"`
+    ClaimMode           string        `env:"DUE_JOB_CHECKER_CLAIM_MODE" default:"skip_locked" oneof:"skip_locked,advisory_xact_lock"`
+    LockKey             int           `env:"JOB_CHECKER_LOCK_KEY" default:"1" usage:"advisory lock key of the advisory_xact_lock claim mode"`
+    DispatchConcurrency int           `env:"DUE_JOB_CHECKER_DISPATCH_CONCURRENCY" default:"16" min:"1" usage:"jobs sent concurrently"`
+    MinPollInterval     time.Duration `env:"DUE_JOB_CHECKER_MIN_POLL_INTERVAL_MS" default:"10" unit:"ms" min:"1"`
+    MaxPollInterval     time.Duration `env:"DUE_JOB_CHECKER_MAX_POLL_INTERVAL_MS" default:"5000" unit:"ms" min:"1"`
+}
+
+func (c *DueJobChecker) Validate() error {
+    var err error
+    if c.MinPollInterval > c.MaxPollInterval {
+        err = errors.New("DUE_JOB_CHECKER_MIN_POLL_INTERVAL_MS must not exceed DUE_JOB_CHECKER_MAX_POLL_INTERVAL_MS")
+    }
+    return errors.Join(err, c.Database.Validate(), c.Metrics.Validate(), c.Tracing.Validate(), c.Retry.Validate())
+}
+
+type JobFixer struct {
+    Database Database
+    Metrics  Metrics
+    Tracing  Tracing
+    Logging  Logging
+    Shutdown Shutdown
+    Retry    Retry
+    Health   Health
+
+    HTTPAddr                     string        `env:"JOB_FIXER_HTTP_ADDR" default:":9102" usage:"address of /metrics, /healthz and /readyz, unserved when set empty"`
+    MaxProcessingTime            time.Duration `env:"JOB_MAXIMUM_PROCESSING_TIME_IN_SECONDS" default:"15" unit:"s" min:"1" usage:"time in progress before a job is requeued, also the interval between passes"`
+    DeliveryRetentionDays        int           `env:"JOB_DELIVERY_RETENTION_DAYS" default:"7" min:"1"`
+    EventRetentionDays           int           `env:"JOB_EVENT_RETENTION_DAYS" default:"7" min:"1" usage:"days job events are kept"`
+    CompletedRetentionDays       int           `env:"JOB_COMPLETED_RETENTION_DAYS" default:"7" min:"1" usage:"days completed jobs are kept after their claim, at least JOB_EVENT_RETENTION_DAYS"`
+    MaxAttempts                  int           `env:"JOB_MAX_ATTEMPTS" default:"5" min:"1" usage:"failed attempts before a job is abandoned"`
+    PartitionDaysAhead           int           `env:"JOB_PARTITION_DAYS_AHEAD" default:"7" min:"1"`
+    PartitionRetentionDays       int           `env:"JOB_PARTITION_RETENTION_DAYS" default:"30" min:"1"`
+    PartitionMaintenanceInterval time.Duration `env:"JOB_PARTITION_MAINTENANCE_INTERVAL_IN_SECONDS" default:"3600" unit:"s" min:"1"`
+}
+
+func (c *JobFixer) Validate() error {
+    var errs []error
+    if c.Health.MaxStall <= c.MaxProcessingTime {
+        errs = append(errs, errors.New("HEALTH_MAX_STALL_IN_SECONDS must exceed JOB_MAXIMUM_PROCESSING_TIME_IN_SECONDS, the fixer sleeps that long between passes"))
+    }
+    if c.CompletedRetentionDays < c.EventRetentionDays {
+        errs = append(errs, errors.New("JOB_COMPLETED_RETENTION_DAYS must be at least JOB_EVENT_RETENTION_DAYS, a completed job would go before its events"))
+    }
+    return errors.Join(append(errs, c.Database.Validate(), c.Metrics.Validate(), c.Tracing.Validate(), c.Retry.Validate())...)
+}
+
+// SingleNode runs the api-server, the due-job checker and the job fixer in one process on the in-memory store,
+// their settings keep the names they have in each service.
+type SingleNode struct {
+    Metrics  Metrics
+    Tracing  Tracing
+    Logging  Logging
+    Shutdown Shutdown
+    Retry    Retry
+    Health   Health
+    Aging    PriorityAging
+
+    Addr             string `env:"API_SERVER_ADDR" default:":8081" usage:"address the API, /metrics, /healthz and /readyz are served on"`
+    MetadataMaxBytes int    `env:"JOB_METADATA_MAX_BYTES" default:"4096" min:"1" usage:"size limit of the metadata of a job"`
+
+    BatchSize           int           `env:"DUE_JOB_CHECKER_BATCH_SIZE" default:"1000" min:"1" usage:"jobs claimed at once"`
+    DispatchConcurrency int           `env:"DUE_JOB_CHECKER_DISPATCH_CONCURRENCY" default:"16" min:"1" usage:"jobs sent concurrently"`
+    MinPollInterval     time.Duration `env:"DUE_JOB_CHECKER_MIN_POLL_INTERVAL_MS" default:"10" unit:"ms" min:"1"`
+    MaxPollInterval     time.Duration `env:"DUE_JOB_CHECKER_MAX_POLL_INTERVAL_MS" default:"5000" unit:"ms" min:"1"`
+
+    MaxProcessingTime      time.Duration `env:"JOB_MAXIMUM_PROCESSING_TIME_IN_SECONDS" default:"15" unit:"s" min:"1" usage:"time in progress before a job is requeued, also the interval between passes"`
+    DeliveryRetentionDays  int           `env:"JOB_DELIVERY_RETENTION_DAYS" default:"7" min:"1"`
+    EventRetentionDays     int           `env:"JOB_EVENT_RETENTION_DAYS" default:"7" min:"1" usage:"days job events are kept"`
+    CompletedRetentionDays int           `env:"JOB_COMPLETED_RETENTION_DAYS" default:"7" min:"1" usage:"days completed jobs are kept after their claim, at least JOB_EVENT_RETENTION_DAYS"`
+    MaxAttempts            int           `env:"JOB_MAX_ATTEMPTS" default:"5" min:"1" usage:"failed attempts before a job is abandoned"`
+}
+
+func (c *SingleNode) Validate() error {
+    var errs []error
+    if c.MinPollInterval > c.MaxPollInterval {
+        errs = append(errs, errors.New("DUE_JOB_CHECKER_MIN_POLL_INTERVAL_MS must not exceed DUE_JOB_CHECKER_MAX_POLL_INTERVAL_MS"))
+    }
+    if c.Health.MaxStall <= c.MaxProcessingTime {
+        errs = append(errs, errors.New("HEALTH_MAX_STALL_IN_SECONDS must exceed JOB_MAXIMUM_PROCESSING_TIME_IN_SECONDS, the fixer sleeps that long between passes"))
+    }
+    if c.CompletedRetentionDays < c.EventRetentionDays {
+        errs = append(errs, errors.New("JOB_COMPLETED_RETENTION_DAYS must be at least JOB_EVENT_RETENTION_DAYS, a completed job would go before its events"))
+    }
+    return errors.Join(append(errs, c.Metrics.Validate(), c.Tracing.Validate(), c.Retry.Validate())...)
+}
+
+type Migrate struct {
+    Database Database
+    Logging  Logging
+}
+
+func (c *Migrate) Validate() error {
+    return c.Database.Validate()
+}
@@ -33,7 +33,7 @@ func sendRequest() {
             Steps: []Step{
                 {
                     Type:     "job",
-                    Metadata: "{ 'any': 'thing 1' }",
+                    Metadata: `{"any": "thing 1"}`,
                 },
                 {
                     Type:        "wait_certain_period",
@@ -42,7 +42,7 @@ func sendRequest() {
                 },
                 {
                     Type:     "job",
-                    Metadata: "{ 'any': 'thing 2' }",
+                    Metadata: `{"any": "thing 2"}`,
                 },
             },
             Subscribers: rand.Intn(10000) + 1, // Random number between 1 and 1000
@@ -9,6 +9,87 @@ type Job struct {
     Status   JobStatus `json:"status"`
     Metadata string    `json:"metadata"`
     TenantId int       `json:"tenant_id"`
+    // TenantType is the type of the tenant the job was scheduled for, empty when the request did not tell
+    TenantType TenantType `json:"tenant_type,omitempty"`
+    DeliveryId string     `json:"delivery_id"`
+    Attempts   int        `json:"attempts"`
+    ClaimedAt  *time.Time `json:"claimed_at,omitempty"`
+    // ExpiresAt is when the job stops being worth sending, nil when it never expires
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+    // SequenceId and Subscriber tell which /schedule-job request and which of its subscribers the job belongs to
+    SequenceId string `json:"sequence_id,omitempty"`
+    Subscriber int    `json:"subscriber"`
+    // TraceParent is the W3C trace context of the /schedule-job request that created the job, empty without tracing
+    TraceParent string `json:"trace_parent,omitempty"`
+
+    Dependencies JobDependencySpec `json:"-"`
+}
+
+type DependencyFailurePolicy string
+
+const (
+    // DependencyFailureCancel cancels the dependent job when a dependency is abandoned or cancelled,
+    // the cancellation propagates to the dependent's own dependents
+    DependencyFailureCancel DependencyFailurePolicy = "cancel"
+    // DependencyFailureSkip skips the dependent job, a skipped job counts as done for its own dependents
+    DependencyFailureSkip DependencyFailurePolicy = "skip"
+)
+
+// JobDependencySpec lists the jobs a job waits for. It is resolved when the job is inserted:
+// the jobs in DependsOn plus the jobs whose metadata contains DependsOnMetadata, among those not done yet.
+type JobDependencySpec struct {
+    DependsOn           []int                   `json:"depends_on,omitempty"`
+    DependsOnMetadata   string                  `json:"depends_on_metadata,omitempty"`
+    OnDependencyFailure DependencyFailurePolicy `json:"on_dependency_failure,omitempty"`
+}
+
+func (d JobDependencySpec) IsEmpty() bool {
+    return len(d.DependsOn) == 0 && d.DependsOnMetadata == ""
+}
+
+// JobMessage is what the due-job checker sends to the next service for a due job.
+// Delivery is at-least-once: a message with the same DeliveryId can arrive more than once,
+// e.g. after a crash between sending and recording the delivery, and consumers must process it idempotently.
+type JobMessage struct {
+    DeliveryId string    `json:"delivery_id"`
+    JobId      int       `json:"job_id"`
+    Attempt    int       `json:"attempt"`
+    DueAt      time.Time `json:"due_at"`
+    // TraceParent is the W3C trace context of the dispatch span, for consumers to continue the trace
+    TraceParent string `json:"trace_parent,omitempty"`
+}
+
+// IsExpired tells whether the job is not worth sending anymore at now.
+func (j Job) IsExpired(now time.Time) bool {
+    return j.ExpiresAt != nil && now.After(*j.ExpiresAt)
+}
+
+// JobDelivery is a recorded send of a job, see job_deliveries.
+type JobDelivery struct {
+    DeliveryId  string    `json:"delivery_id"`
+    Attempt     int       `json:"attempt"`
+    DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// JobEvent is a status change of a job recorded in job_events.
+type JobEvent struct {
+    Id int64 `json:"id"`
+    // FromStatus is nil for the event created along with the job
+    FromStatus *JobStatus `json:"from_status"`
+    ToStatus   JobStatus  `json:"to_status"`
+    Attempt    int        `json:"attempt"`
+    Actor      string     `json:"actor"`
+    Reason     string     `json:"reason,omitempty"`
+    CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (j Job) Message() JobMessage {
+    return JobMessage{
+        DeliveryId: j.DeliveryId,
+        JobId:      j.Id,
+        Attempt:    j.Attempts,
+        DueAt:      j.DueAt,
+    }
 }
 
 type JobStatus int
@@ -18,6 +99,14 @@ const (
     JobStatusInProgress
     JobStatusCompleted
     JobStatusFailed
+    // JobStatusAbandoned jobs failed JOB_MAX_ATTEMPTS times and are not retried
+    JobStatusAbandoned
+    // JobStatusCancelled jobs will not run because a dependency was abandoned or cancelled
+    JobStatusCancelled
+    // JobStatusSkipped jobs did not run because a dependency failed, their dependents still run
+    JobStatusSkipped
+    // JobStatusExpired jobs were claimed after their expires_at and dropped instead of sent
+    JobStatusExpired
 )
 
 func (s JobStatus) String() string {
@@ -33,5 +122,17 @@ func (s JobStatus) String() string {
     if s == JobStatusFailed {
         return "JobStatusFailed"
     }
+    if s == JobStatusAbandoned {
+        return "JobStatusAbandoned"
+    }
+    if s == JobStatusCancelled {
+        return "JobStatusCancelled"
+    }
+    if s == JobStatusSkipped {
+        return "JobStatusSkipped"
+    }
+    if s == JobStatusExpired {
+        return "JobStatusExpired"
+    }
     return "JobStatusUnknown"
 }
@@ -1,6 +1,13 @@
 package entity
 
 type Sequence struct {
+    // Id identifies the jobs scheduled by one /schedule-job request
+    Id          string `json:"id"`
     Steps       []Step `json:"steps"`
     Subscribers int    `json:"subscribers"`
+    // TenantId and TenantType are those of the tenant the jobs are scheduled for
+    TenantId   int        `json:"tenant_id"`
+    TenantType TenantType `json:"tenant_type,omitempty"`
+    // TraceParent is stored with every job of the sequence, see Job.TraceParent
+    TraceParent string `json:"-"`
 }
@@ -1,6 +1,10 @@
 package entity
 
-import "time"
+import (
+    "bytes"
+    "encoding/json"
+    "time"
+)
 
 type Step interface {
     StepType() StepType
@@ -53,12 +57,61 @@ func (s StepWaitSpecificDate) StepType() StepType {
 
 type StepJob struct {
     Metadata string `json:"metadata,omitempty"`
+    JobDependencySpec
+
+    // ExpiresAt (RFC3339) and MaxLateness bound how late the job may be sent, the earliest of the two applies
+    ExpiresAt         string    `json:"expires_at,omitempty"`
+    MaxLatenessPeriod int       `json:"max_lateness_period,omitempty"`
+    MaxLatenessUnit   DelayUnit `json:"max_lateness_unit,omitempty"`
 }
 
 func (s StepJob) StepType() StepType {
     return StepTypeJob
 }
 
+// UnmarshalJSON accepts metadata and depends_on_metadata either as a raw JSON value ({"any": "thing"})
+// or as a string holding the JSON document ("{\"any\": \"thing\"}").
+func (s *StepJob) UnmarshalJSON(data []byte) error {
+    var raw struct {
+        Metadata            json.RawMessage         `json:"metadata"`
+        DependsOn           []int                   `json:"depends_on"`
+        DependsOnMetadata   json.RawMessage         `json:"depends_on_metadata"`
+        OnDependencyFailure DependencyFailurePolicy `json:"on_dependency_failure"`
+        ExpiresAt           string                  `json:"expires_at"`
+        MaxLatenessPeriod   int                     `json:"max_lateness_period"`
+        MaxLatenessUnit     DelayUnit               `json:"max_lateness_unit"`
+    }
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return err
+    }
+    var err error
+    if s.Metadata, err = decodeJSONDocument(raw.Metadata); err != nil {
+        return err
+    }
+    if s.DependsOnMetadata, err = decodeJSONDocument(raw.DependsOnMetadata); err != nil {
+        return err
+    }
+    s.DependsOn = raw.DependsOn
+    s.OnDependencyFailure = raw.OnDependencyFailure
+    s.ExpiresAt = raw.ExpiresAt
+    s.MaxLatenessPeriod = raw.MaxLatenessPeriod
+    s.MaxLatenessUnit = raw.MaxLatenessUnit
+    return nil
+}
+
+func decodeJSONDocument(raw json.RawMessage) (string, error) {
+    raw = bytes.TrimSpace(raw)
+    if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+        return "", nil
+    }
+    if raw[0] == '"' {
+        var document string
+        err := json.Unmarshal(raw, &document)
+        return document, err
+    }
+    return string(raw), nil
+}
+
 type StepWaitWeekDay struct {
     WeekDays []WeekDay `json:"weekdays"`
 }
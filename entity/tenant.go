@@ -1,5 +1,7 @@
 package entity
 
+import "slices"
+
 type Tenant struct {
     Id   int        `json:"id"`
     Type TenantType `json:"type"`
@@ -12,3 +14,28 @@ const (
     TenantTypeSme        TenantType = "sme"
     TenantTypeEnterprise TenantType = "enterprise"
 )
+
+// TenantTypes lists the tenant types by priority band, the band of a tenant type is its index: the jobs of new
+// tenants are claimed first, those of enterprises last.
+var TenantTypes = []TenantType{TenantTypeNew, TenantTypeSme, TenantTypeEnterprise}
+
+// TenantTypeUnknown is reported for jobs scheduled without a tenant type
+const TenantTypeUnknown TenantType = "unknown"
+
+// DefaultTenantId is the tenant of the jobs scheduled without one
+const DefaultTenantId = 1
+
+// Priority returns the priority band of the jobs of tenant type t. Jobs scheduled without a tenant type share the
+// band of new tenants, which every job was in before priorities were derived from the tenant type.
+func (t TenantType) Priority() int {
+    return max(slices.Index(TenantTypes, t), 0)
+}
+
+// Valid tells whether t is one of the tenant types, TenantTypeUnknown is not.
+func (t TenantType) Valid() bool {
+    switch t {
+    case TenantTypeNew, TenantTypeSme, TenantTypeEnterprise:
+        return true
+    }
+    return false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    . "go-pg-bench/common"
+    "go-pg-bench/config"
+    "log/slog"
+    "os"
+)
+
+func main() {
+    flag.Usage = func() {
+        fmt.Fprintln(os.Stderr, "Usage: migrate [flags] <up|down|status> [-steps N]")
+        flag.PrintDefaults()
+    }
+    var cfg config.Migrate
+    config.MustLoad(&cfg)
+    SetupLogger("migrate", cfg.Logging)
+    if flag.NArg() < 1 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    // a migration may rewrite a whole table, it is not bound by the timeouts of the services' queries
+    cfg.Database.StatementTimeout, cfg.Database.QueryTimeout = 0, 0
+    conn := OpenDB(cfg.Database)
+    defer conn.Close()
+
+    switch flag.Arg(0) {
+    case "up":
+        applied, err := MigrateUp(conn)
+        if err != nil {
+            Fatal("Failed to apply migrations", ErrorAttr(err))
+        }
+        slog.Info("Applied migrations", "count", applied)
+    case "down":
+        downFlags := flag.NewFlagSet("down", flag.ExitOnError)
+        steps := downFlags.Int("steps", 1, "number of migrations to roll back")
+        if err := downFlags.Parse(flag.Args()[1:]); err != nil {
+            Fatal("Invalid down flags", ErrorAttr(err))
+        }
+        reverted, err := MigrateDown(conn, *steps)
+        if err != nil {
+            Fatal("Failed to revert migrations", ErrorAttr(err))
+        }
+        slog.Info("Reverted migrations", "count", reverted)
+    case "status":
+        statuses, err := GetMigrationStatus(conn)
+        if err != nil {
+            Fatal("Failed to read the migration status", ErrorAttr(err))
+        }
+        for _, s := range statuses {
+            appliedAt := "pending"
+            if s.AppliedAt != nil {
+                appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+            }
+            fmt.Printf("%04d  %-40s %s\n", s.Version, s.Name, appliedAt)
+        }
+    default:
+        flag.Usage()
+        os.Exit(2)
+    }
+}
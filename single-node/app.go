@@ -0,0 +1,111 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "go-pg-bench/api-server/handlers"
+    "go-pg-bench/common"
+    "go-pg-bench/config"
+    "go-pg-bench/store"
+    "go-pg-bench/worker-due-job-checker/checker"
+    "go-pg-bench/worker-job-fixer/fixer"
+    "log/slog"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// The single node runs the api-server, the due-job checker and the job fixer in one process on the in-memory
+// job store, for local development and small deployments. Jobs do not survive a restart.
+func main() {
+    var cfg config.SingleNode
+    config.MustLoad(&cfg)
+    common.SetupLogger("single-node", cfg.Logging)
+
+    // each service records its own actor in the job events of the shared store
+    apiStore := store.NewMemory(common.ActorName("api-server"))
+    checkerStore := apiStore.WithActor(common.ActorName("due-job-checker"))
+    fixerStore := apiStore.WithActor(common.ActorName("job-fixer"))
+    // checkers are woken up within the process instead of by Postgres notifications
+    notifications := common.NewLocalNotifications()
+
+    handlers.RegisterMetrics()
+    checker.RegisterMetrics()
+    fixer.RegisterMetrics()
+    mux := http.NewServeMux()
+    flushMetrics := common.StartMetrics("single_node", mux, cfg.Metrics)
+    defer flushMetrics()
+    flushTraces := common.StartTracing("single-node", cfg.Tracing)
+    defer flushTraces()
+    checkerHealth := common.NewHealth(nil, cfg.Health.MaxStall)
+    fixerHealth := common.NewHealth(nil, cfg.Health.MaxStall)
+    common.RegisterHealth(mux, checkerHealth, fixerHealth)
+    handlers.Register(mux, handlers.Options{
+        JobStore:         apiStore,
+        Notify:           notifications.Notify,
+        MetadataMaxBytes: cfg.MetadataMaxBytes,
+    })
+
+    // On SIGTERM the API stops accepting connections, the checker stops claiming and the fixer stops after its
+    // current pass, all of them get until the shutdown timeout to finish what they started
+    stop, drain := common.ShutdownContexts(cfg.Shutdown.Timeout)
+    // a worker giving up stops the whole node, as it would restart the worker on its own
+    stop, giveUp := context.WithCancel(stop)
+    defer giveUp()
+    retry := common.NewFailurePolicy(cfg.Retry)
+    var workers sync.WaitGroup
+    workerErrs := make(chan error, 2)
+    runWorker := func(name string, run func() error) {
+        workers.Add(1)
+        go func() {
+            defer workers.Done()
+            if err := run(); err != nil {
+                workerErrs <- fmt.Errorf("%s: %w", name, err)
+                giveUp()
+            }
+        }()
+    }
+    runWorker("due-job-checker", func() error {
+        return checker.Run(stop, drain, checker.Options{
+            JobStore:            checkerStore,
+            ClaimMode:           "memory",
+            BatchSize:           cfg.BatchSize,
+            DispatchConcurrency: cfg.DispatchConcurrency,
+            Send:                checker.SendMessageToQueue,
+            MinPollInterval:     cfg.MinPollInterval,
+            MaxPollInterval:     cfg.MaxPollInterval,
+            Aging:               common.PriorityAging{Intervals: cfg.Aging.Intervals()},
+            Retry:               retry,
+            Notifications:       notifications.Listen(),
+            Notify:              notifications.Notify,
+            Health:              checkerHealth,
+        })
+    })
+    runWorker("job-fixer", func() error {
+        return fixer.Run(stop, drain, fixer.Options{
+            JobStore:          fixerStore,
+            MaxProcessingTime: cfg.MaxProcessingTime,
+            Retention: store.Retention{
+                Jobs:       time.Duration(cfg.CompletedRetentionDays) * 24 * time.Hour,
+                Deliveries: time.Duration(cfg.DeliveryRetentionDays) * 24 * time.Hour,
+                Events:     time.Duration(cfg.EventRetentionDays) * 24 * time.Hour,
+            },
+            MaxAttempts: cfg.MaxAttempts,
+            Retry:       retry,
+            Notify:      notifications.Notify,
+            Health:      fixerHealth,
+        })
+    })
+
+    err := handlers.Serve(stop, drain, cfg.Addr, mux)
+    giveUp()
+    workers.Wait()
+    if err != nil {
+        common.Fatal("Failed to serve HTTP", common.ErrorAttr(err))
+    }
+    close(workerErrs)
+    if err = <-workerErrs; err != nil {
+        common.Fatal("Giving up", common.ErrorAttr(err))
+    }
+    slog.Info("Shutting down...")
+}
@@ -0,0 +1,686 @@
+package store
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/json"
+    "fmt"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "reflect"
+    "slices"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Memory keeps the jobs in the process. It follows the semantics of Postgres, down to the job events, so the
+// pipeline can be tested without a database; nothing survives the process.
+type Memory struct {
+    actor string
+    state *memoryState
+}
+
+var _ JobStore = (*Memory)(nil)
+
+type memoryState struct {
+    mu          sync.Mutex
+    lastJobId   int
+    lastEventId int64
+    jobs        map[int]*entity.Job
+    edges       []*memoryEdge
+    deliveries  map[string]memoryDelivery
+    events      []memoryEvent
+}
+
+// memoryEdge is a row of job_dependencies
+type memoryEdge struct {
+    jobId     int
+    dependsOn int
+    onFailure entity.DependencyFailurePolicy
+    satisfied bool
+}
+
+// memoryDelivery is a row of job_deliveries
+type memoryDelivery struct {
+    jobId int
+    entity.JobDelivery
+}
+
+// memoryEvent is a row of job_events
+type memoryEvent struct {
+    jobId int
+    entity.JobEvent
+}
+
+// NewMemory returns an empty store recording its changes under actor.
+func NewMemory(actor string) *Memory {
+    return &Memory{
+        actor: actor,
+        state: &memoryState{jobs: map[int]*entity.Job{}, deliveries: map[string]memoryDelivery{}},
+    }
+}
+
+// WithActor returns a store sharing the jobs of m that records its changes under actor,
+// one per service running against the same jobs.
+func (m *Memory) WithActor(actor string) *Memory {
+    return &Memory{actor: actor, state: m.state}
+}
+
+func (m *Memory) InsertJobs(ctx context.Context, jobTemplates []entity.Job, sequence entity.Sequence) (int, error) {
+    if err := ctx.Err(); err != nil {
+        return 0, err
+    }
+    for _, job := range jobTemplates {
+        if job.Metadata != "" && !json.Valid([]byte(job.Metadata)) {
+            return 0, fmt.Errorf("invalid job metadata: %s", job.Metadata)
+        }
+    }
+
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    // Dependencies are resolved once per template, before inserting, so a job never waits on a job of the same request
+    dependencies := make([][]int, len(jobTemplates))
+    for jobIndex, job := range jobTemplates {
+        var err error
+        if dependencies[jobIndex], err = s.resolveDependencies(job.Dependencies); err != nil {
+            return 0, fmt.Errorf("failed to resolve job dependencies: %w", err)
+        }
+    }
+
+    // Delivery ids are drawn before any job is added, a failure leaves nothing inserted
+    deliveryIds := make([]string, len(jobTemplates)*sequence.Subscribers)
+    for i := range deliveryIds {
+        var err error
+        if deliveryIds[i], err = newDeliveryId(); err != nil {
+            return 0, err
+        }
+    }
+
+    now := time.Now().UTC()
+    inserted := 0
+    for subscriber := 0; subscriber < sequence.Subscribers; subscriber++ {
+        for jobIndex, template := range jobTemplates {
+            deliveryId := deliveryIds[inserted]
+            s.lastJobId++
+            job := &entity.Job{
+                Id:          s.lastJobId,
+                DueAt:       template.DueAt,
+                Priority:    template.Priority,
+                Status:      template.Status,
+                Metadata:    template.Metadata,
+                TenantId:    tenantIdOf(template),
+                TenantType:  template.TenantType,
+                DeliveryId:  deliveryId,
+                ExpiresAt:   template.ExpiresAt,
+                SequenceId:  sequence.Id,
+                Subscriber:  subscriber,
+                TraceParent: sequence.TraceParent,
+            }
+            s.jobs[job.Id] = job
+            s.record(job, nil, entity.JobStatusInitialized, m.actor, reasonScheduled, now)
+            for _, dependsOn := range dependencies[jobIndex] {
+                s.edges = append(s.edges, &memoryEdge{jobId: job.Id, dependsOn: dependsOn, onFailure: onFailure(template.Dependencies)})
+            }
+            inserted++
+        }
+    }
+    return inserted, nil
+}
+
+func (s *memoryState) resolveDependencies(spec entity.JobDependencySpec) ([]int, error) {
+    if spec.IsEmpty() {
+        return nil, nil
+    }
+    var filter interface{}
+    if spec.DependsOnMetadata != "" {
+        if err := json.Unmarshal([]byte(spec.DependsOnMetadata), &filter); err != nil {
+            return nil, err
+        }
+    }
+
+    var ids []int
+    for _, job := range s.sortedJobs() {
+        if slices.Contains(doneStatuses, job.Status) {
+            continue
+        }
+        if slices.Contains(spec.DependsOn, job.Id) || (filter != nil && metadataContains(job.Metadata, filter)) {
+            ids = append(ids, job.Id)
+        }
+    }
+    return ids, unresolvedDependencies(spec, ids)
+}
+
+func (m *Memory) FindJobs(ctx context.Context, filter JobFilter) (JobPage, error) {
+    limit := filter.limit()
+    var metadata interface{}
+    if filter.MetadataValue != nil {
+        metadata = metadataFilter(filter.MetadataKey, *filter.MetadataValue)
+    }
+
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    jobs := make([]entity.Job, 0)
+    for _, job := range s.sortedJobs() {
+        switch {
+        case len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, job.Status),
+            filter.TenantId != 0 && job.TenantId != filter.TenantId,
+            filter.SequenceId != "" && job.SequenceId != filter.SequenceId,
+            filter.Subscriber != nil && job.Subscriber != *filter.Subscriber,
+            filter.DueFrom != nil && job.DueAt.Before(*filter.DueFrom),
+            filter.DueTo != nil && !job.DueAt.Before(*filter.DueTo),
+            metadata != nil && !metadataContains(job.Metadata, metadata),
+            metadata == nil && filter.MetadataKey != "" && !metadataHasKey(job.Metadata, filter.MetadataKey),
+            filter.After != nil && !afterCursor(job, *filter.After):
+            continue
+        }
+        jobs = append(jobs, *job)
+        // one extra job tells whether there is a next page
+        if len(jobs) > limit {
+            break
+        }
+    }
+    return newJobPage(jobs, limit), nil
+}
+
+func afterCursor(job *entity.Job, cursor JobCursor) bool {
+    return job.DueAt.After(cursor.DueAt) || (job.DueAt.Equal(cursor.DueAt) && job.Id > cursor.Id)
+}
+
+func (m *Memory) GetJob(ctx context.Context, id int) (*JobDetails, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    job, found := s.jobs[id]
+    if !found {
+        return nil, ErrJobNotFound
+    }
+    details := &JobDetails{Job: *job, DependsOn: make([]int, 0), Deliveries: make([]entity.JobDelivery, 0)}
+    for _, edge := range s.edges {
+        if edge.jobId == id {
+            details.DependsOn = append(details.DependsOn, edge.dependsOn)
+        }
+    }
+    sort.Ints(details.DependsOn)
+    for _, delivery := range s.deliveries {
+        if delivery.jobId == id {
+            details.Deliveries = append(details.Deliveries, delivery.JobDelivery)
+        }
+    }
+    sort.Slice(details.Deliveries, func(i, j int) bool {
+        return details.Deliveries[i].DeliveredAt.Before(details.Deliveries[j].DeliveredAt)
+    })
+    details.Events = s.jobEvents(id)
+    return details, nil
+}
+
+func (m *Memory) GetJobEvents(ctx context.Context, jobId int) ([]entity.JobEvent, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    if _, found := s.jobs[jobId]; !found {
+        return nil, ErrJobNotFound
+    }
+    return s.jobEvents(jobId), nil
+}
+
+// jobEvents returns the events of a job in the order they were recorded.
+func (s *memoryState) jobEvents(jobId int) []entity.JobEvent {
+    events := make([]entity.JobEvent, 0)
+    for _, event := range s.events {
+        if event.jobId == jobId {
+            events = append(events, event.JobEvent)
+        }
+    }
+    return events
+}
+
+// ClaimDueJobs claims in the order of the claim query, there is a single claimer at a time so acquired is always true.
+func (m *Memory) ClaimDueJobs(ctx context.Context, batchSize int, aging common.PriorityAging) ([]entity.Job, bool, error) {
+    if err := ctx.Err(); err != nil {
+        return nil, false, err
+    }
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    var due []*entity.Job
+    for _, job := range s.sortedJobs() {
+        if job.Status == entity.JobStatusInitialized && !job.DueAt.After(now) && !job.IsExpired(now) && !s.waiting(job.Id) {
+            due = append(due, job)
+        }
+    }
+    // aged priority, then due_at; the sort is stable on the id order of sortedJobs
+    sort.SliceStable(due, func(i, j int) bool {
+        pi := aging.EffectivePriority(due[i].Priority, now.Sub(due[i].DueAt))
+        pj := aging.EffectivePriority(due[j].Priority, now.Sub(due[j].DueAt))
+        if pi != pj {
+            return pi < pj
+        }
+        return due[i].DueAt.Before(due[j].DueAt)
+    })
+
+    jobs := make([]entity.Job, 0, min(batchSize, len(due)))
+    for _, job := range due[:min(batchSize, len(due))] {
+        claimedAt := now
+        job.Attempts++
+        job.ClaimedAt = &claimedAt
+        s.transition(job, entity.JobStatusInProgress, m.actor, reasonClaimed, now)
+        jobs = append(jobs, *job)
+    }
+    return jobs, true, nil
+}
+
+func (m *Memory) EarliestPendingDueAt(ctx context.Context) (*time.Time, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    var earliest *time.Time
+    for _, job := range s.jobs {
+        if job.Status != entity.JobStatusInitialized || job.IsExpired(now) || s.waiting(job.Id) {
+            continue
+        }
+        if earliest == nil || job.DueAt.Before(*earliest) {
+            dueAt := job.DueAt
+            earliest = &dueAt
+        }
+    }
+    return earliest, nil
+}
+
+func (m *Memory) DeliveredIds(ctx context.Context, deliveryIds []string) (map[string]bool, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    delivered := map[string]bool{}
+    for _, deliveryId := range deliveryIds {
+        if _, found := s.deliveries[deliveryId]; found {
+            delivered[deliveryId] = true
+        }
+    }
+    return delivered, nil
+}
+
+func (m *Memory) CompleteJobs(ctx context.Context, jobs []entity.Job, reason string) ([]int, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    for _, job := range jobs {
+        // another replica may have recorded the same delivery when the job was sent twice
+        if _, found := s.deliveries[job.DeliveryId]; !found {
+            s.deliveries[job.DeliveryId] = memoryDelivery{
+                jobId:       job.Id,
+                JobDelivery: entity.JobDelivery{DeliveryId: job.DeliveryId, Attempt: job.Attempts, DeliveredAt: now},
+            }
+        }
+    }
+    stale := s.transitionClaimed(jobs, entity.JobStatusCompleted, m.actor, reason, now)
+    completed := map[int]bool{}
+    for _, job := range jobs {
+        completed[job.Id] = !slices.Contains(stale, job.Id)
+    }
+    for _, edge := range s.edges {
+        if completed[edge.dependsOn] {
+            edge.satisfied = true
+        }
+    }
+    return stale, nil
+}
+
+func (m *Memory) FailJobs(ctx context.Context, jobs []entity.Job, reason string) ([]int, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+    return s.transitionClaimed(jobs, entity.JobStatusFailed, m.actor, reason, time.Now().UTC()), nil
+}
+
+func (m *Memory) ExpireJobs(ctx context.Context, jobs []entity.Job, reason string) ([]int, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+    return s.transitionClaimed(jobs, entity.JobStatusExpired, m.actor, reason, time.Now().UTC()), nil
+}
+
+func (m *Memory) ReleaseJobs(ctx context.Context, jobs []entity.Job) (int64, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+    stale := s.transitionClaimed(jobs, entity.JobStatusInitialized, m.actor, reasonReleased, time.Now().UTC())
+    return int64(len(jobs) - len(stale)), nil
+}
+
+func (m *Memory) ArchiveJobs(ctx context.Context, retention Retention) (Archived, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    var archived Archived
+    now := time.Now().UTC()
+    for id, job := range s.jobs {
+        since := job.DueAt
+        if job.ClaimedAt != nil {
+            since = *job.ClaimedAt
+        }
+        if job.Status == entity.JobStatusCompleted && since.Before(now.Add(-retention.Jobs)) {
+            delete(s.jobs, id)
+            archived.Jobs++
+        }
+    }
+    for deliveryId, delivery := range s.deliveries {
+        if delivery.DeliveredAt.Before(now.Add(-retention.Deliveries)) {
+            delete(s.deliveries, deliveryId)
+            archived.Deliveries++
+        }
+    }
+    events := s.events[:0]
+    for _, event := range s.events {
+        if event.CreatedAt.Before(now.Add(-retention.Events)) {
+            archived.Events++
+            continue
+        }
+        events = append(events, event)
+    }
+    s.events = events
+    return archived, nil
+}
+
+func (m *Memory) AbandonJobs(ctx context.Context, maxAttempts int) (int64, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    var abandoned int64
+    for _, job := range s.sortedJobs() {
+        if job.Status == entity.JobStatusFailed && job.Attempts >= maxAttempts {
+            s.transition(job, entity.JobStatusAbandoned, m.actor, abandonReason(maxAttempts), now)
+            abandoned++
+        }
+    }
+    return abandoned, nil
+}
+
+func (m *Memory) ExpireQueuedJobs(ctx context.Context) (int64, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    var expired int64
+    for _, job := range s.sortedJobs() {
+        if job.Status == entity.JobStatusInitialized && job.IsExpired(now) {
+            s.transition(job, entity.JobStatusExpired, m.actor, reasonExpiredQueued, now)
+            expired++
+        }
+    }
+    return expired, nil
+}
+
+// ResolveDependencies takes the same steps as the statements of Postgres.ResolveDependencies.
+func (m *Memory) ResolveDependencies(ctx context.Context) (int64, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    var released int64
+    for {
+        for _, edge := range s.edges {
+            if dependency, found := s.jobs[edge.dependsOn]; !edge.satisfied && (!found || slices.Contains(doneStatuses, dependency.Status)) {
+                edge.satisfied = true
+                released++
+            }
+        }
+
+        propagated := 0
+        for _, outcome := range dependencyOutcomes {
+            var dependents []*entity.Job
+            for _, job := range s.sortedJobs() {
+                if job.Status == entity.JobStatusInitialized && s.deadDependency(job.Id, outcome.onFailure) {
+                    dependents = append(dependents, job)
+                }
+            }
+            for _, job := range dependents {
+                s.transition(job, outcome.status, m.actor, reasonDependencyDead, now)
+            }
+            propagated += len(dependents)
+        }
+        if propagated == 0 {
+            break
+        }
+    }
+
+    // edges of jobs that will not run anymore are not needed
+    edges := s.edges[:0]
+    for _, edge := range s.edges {
+        if job, found := s.jobs[edge.jobId]; found && slices.Contains(pendingStatuses, job.Status) {
+            edges = append(edges, edge)
+        }
+    }
+    s.edges = edges
+    return released, nil
+}
+
+// deadDependency tells whether the job waits on an abandoned, cancelled or expired job through an edge with onFailure
+func (s *memoryState) deadDependency(jobId int, policy entity.DependencyFailurePolicy) bool {
+    for _, edge := range s.edges {
+        if edge.jobId != jobId || edge.satisfied || edge.onFailure != policy {
+            continue
+        }
+        if dependency, found := s.jobs[edge.dependsOn]; found && slices.Contains(deadStatuses, dependency.Status) {
+            return true
+        }
+    }
+    return false
+}
+
+func (m *Memory) RequeueStuckJobs(ctx context.Context, maxProcessingTime time.Duration, maxAttempts int) (int64, int64, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    var requeued, abandoned int64
+    for _, job := range s.sortedJobs() {
+        if job.Status != entity.JobStatusInProgress {
+            continue
+        }
+        since := job.DueAt
+        if job.ClaimedAt != nil {
+            since = *job.ClaimedAt
+        }
+        if now.Sub(since) <= maxProcessingTime {
+            continue
+        }
+        if job.Attempts >= maxAttempts {
+            s.transition(job, entity.JobStatusAbandoned, m.actor, abandonReason(maxAttempts), now)
+            abandoned++
+            continue
+        }
+        job.DueAt = now
+        s.transition(job, entity.JobStatusInitialized, m.actor, reasonTimedOut, now)
+        requeued++
+    }
+    return requeued, abandoned, nil
+}
+
+func (m *Memory) RetryFailedJobs(ctx context.Context) (int64, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    var retried int64
+    for _, job := range s.sortedJobs() {
+        if job.Status == entity.JobStatusFailed {
+            job.DueAt = now
+            s.transition(job, entity.JobStatusInitialized, m.actor, reasonRetrying, now)
+            retried++
+        }
+    }
+    return retried, nil
+}
+
+func (m *Memory) CountQueuedJobs(ctx context.Context) (int, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    count := 0
+    for _, job := range s.jobs {
+        if job.Status == entity.JobStatusInitialized {
+            count++
+        }
+    }
+    return count, nil
+}
+
+func (m *Memory) OldestOverdueJobs(ctx context.Context) (map[int]time.Duration, error) {
+    s := m.lock()
+    defer s.mu.Unlock()
+
+    now := time.Now().UTC()
+    oldest := map[int]time.Duration{}
+    for _, job := range s.jobs {
+        if job.Status != entity.JobStatusInitialized || job.DueAt.After(now) || job.IsExpired(now) || s.waiting(job.Id) {
+            continue
+        }
+        if overdue := now.Sub(job.DueAt); overdue > oldest[job.Priority] {
+            oldest[job.Priority] = overdue
+        }
+    }
+    return oldest, nil
+}
+
+func (m *Memory) lock() *memoryState {
+    m.state.mu.Lock()
+    return m.state
+}
+
+// transitionClaimed moves the jobs still in progress under the attempt they were claimed with to status, as
+// transitionClaimedJobs does, and returns the ids of the others.
+func (s *memoryState) transitionClaimed(jobs []entity.Job, status entity.JobStatus, actor string, reason string, now time.Time) []int {
+    var stale []int
+    for _, job := range jobs {
+        stored, found := s.jobs[job.Id]
+        if !found || stored.Status != entity.JobStatusInProgress || stored.Attempts != job.Attempts {
+            stale = append(stale, job.Id)
+            continue
+        }
+        s.transition(stored, status, actor, reason, now)
+    }
+    return stale
+}
+
+// transition moves the job to status and records the job event, as transitionJobs does.
+func (s *memoryState) transition(job *entity.Job, status entity.JobStatus, actor string, reason string, now time.Time) {
+    from := job.Status
+    job.Status = status
+    s.record(job, &from, status, actor, reason, now)
+}
+
+func (s *memoryState) record(job *entity.Job, from *entity.JobStatus, to entity.JobStatus, actor string, reason string, now time.Time) {
+    s.lastEventId++
+    s.events = append(s.events, memoryEvent{jobId: job.Id, JobEvent: entity.JobEvent{
+        Id:         s.lastEventId,
+        FromStatus: from,
+        ToStatus:   to,
+        Attempt:    job.Attempts,
+        Actor:      actor,
+        Reason:     reason,
+        CreatedAt:  now,
+    }})
+}
+
+// waiting tells whether the job has a dependency edge not satisfied yet
+func (s *memoryState) waiting(jobId int) bool {
+    for _, edge := range s.edges {
+        if edge.jobId == jobId && !edge.satisfied {
+            return true
+        }
+    }
+    return false
+}
+
+// sortedJobs returns the jobs in (due_at, id) order, the order of FindJobs
+func (s *memoryState) sortedJobs() []*entity.Job {
+    jobs := make([]*entity.Job, 0, len(s.jobs))
+    for _, job := range s.jobs {
+        jobs = append(jobs, job)
+    }
+    sort.Slice(jobs, func(i, j int) bool {
+        if !jobs[i].DueAt.Equal(jobs[j].DueAt) {
+            return jobs[i].DueAt.Before(jobs[j].DueAt)
+        }
+        return jobs[i].Id < jobs[j].Id
+    })
+    return jobs
+}
+
+// metadataContains is the @> operator of JSONB: every key of filter is in metadata with a value containing
+// the filter's, and every element of a filter array is contained in an element of the metadata array.
+func metadataContains(metadata string, filter interface{}) bool {
+    if metadata == "" {
+        return false
+    }
+    var document interface{}
+    if err := json.Unmarshal([]byte(metadata), &document); err != nil {
+        return false
+    }
+    return jsonContains(document, filter)
+}
+
+// metadataHasKey is the ? operator of JSONB: key is a top-level key of metadata, or a string in it or its top-level
+// array.
+func metadataHasKey(metadata string, key string) bool {
+    if metadata == "" {
+        return false
+    }
+    var document interface{}
+    if err := json.Unmarshal([]byte(metadata), &document); err != nil {
+        return false
+    }
+    switch document := document.(type) {
+    case map[string]interface{}:
+        _, found := document[key]
+        return found
+    case []interface{}:
+        return slices.Contains(document, interface{}(key))
+    default:
+        return document == key
+    }
+}
+
+func jsonContains(document interface{}, filter interface{}) bool {
+    switch filter := filter.(type) {
+    case map[string]interface{}:
+        object, ok := document.(map[string]interface{})
+        if !ok {
+            return false
+        }
+        for key, value := range filter {
+            if child, found := object[key]; !found || !jsonContains(child, value) {
+                return false
+            }
+        }
+        return true
+    case []interface{}:
+        array, ok := document.([]interface{})
+        if !ok {
+            return false
+        }
+        for _, value := range filter {
+            if !slices.ContainsFunc(array, func(element interface{}) bool { return jsonContains(element, value) }) {
+                return false
+            }
+        }
+        return true
+    default:
+        return reflect.DeepEqual(document, filter)
+    }
+}
+
+// newDeliveryId returns a random UUID, as GEN_RANDOM_UUID() does for jobs.delivery_id
+func newDeliveryId() (string, error) {
+    var uuid [16]byte
+    if _, err := rand.Read(uuid[:]); err != nil {
+        return "", err
+    }
+    uuid[6] = uuid[6]&0x0f | 0x40
+    uuid[8] = uuid[8]&0x3f | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
+}
@@ -0,0 +1,311 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "github.com/lib/pq"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "slices"
+    "time"
+)
+
+type ClaimMode string
+
+const (
+    // ClaimModeSkipLocked lets replicas claim disjoint batches concurrently through FOR UPDATE SKIP LOCKED alone
+    ClaimModeSkipLocked ClaimMode = "skip_locked"
+    // ClaimModeAdvisoryXactLock lets one replica claim at a time, so batches are taken out strictly in priority order.
+    // The lock is transaction scoped, it is released on commit on the same connection that took it.
+    ClaimModeAdvisoryXactLock ClaimMode = "advisory_xact_lock"
+)
+
+// claimableJobs are the conditions a job of a band must meet to be claimed. The status literal
+// (0 = JobStatusInitialized) must stay in the query for the planner to match the predicate of jobs_claim_index.
+const claimableJobs = `status = 0 AND due_at <= NOW()
+            -- expired jobs are left to ExpireQueuedJobs, they would take batch slots and attempts from live ones
+            AND (expires_at IS NULL OR expires_at > NOW())
+            AND NOT EXISTS (
+                SELECT 1 FROM job_dependencies
+                WHERE job_dependencies.job_id = jobs.id AND NOT job_dependencies.satisfied
+            )`
+
+// ClaimDueJobsQuery is served by the partial index jobs_claim_index (priority, due_at) WHERE status = 0.
+// The aged priority depends on NOW() and cannot be indexed, but within a band it follows due_at: the query reads
+// at most a batch per band in index order, and only those candidates are sorted by aged priority. Candidates locked
+// by a concurrent claim are skipped without being replaced, the batch comes back short then.
+// On the partitioned jobs table the due_at predicate prunes partitions that start in the future.
+// Every claimed job gets a job event from the same statement.
+// Its parameters are the in progress status, the batch size, the aging interval in seconds of each
+// priority band, see PriorityAging.IntervalSeconds, and the actor.
+const ClaimDueJobsQuery = `
+  WITH RECURSIVE bands (priority) AS (
+      -- the priorities with due jobs, skipping from one band to the next in the index
+      (SELECT priority FROM jobs WHERE status = 0 AND due_at <= NOW() AND priority IS NOT NULL ORDER BY priority LIMIT 1)
+      UNION ALL
+      SELECT (
+          SELECT jobs.priority FROM jobs
+          WHERE jobs.status = 0 AND jobs.due_at <= NOW() AND jobs.priority > bands.priority
+          ORDER BY jobs.priority
+          LIMIT 1
+      )
+      FROM bands
+      WHERE bands.priority IS NOT NULL
+  ), candidates AS (
+      SELECT band.id, band.due_at, band.priority
+      FROM bands, LATERAL (
+          SELECT id, due_at, priority FROM jobs
+          WHERE priority = bands.priority AND ` + claimableJobs + `
+          ORDER BY due_at
+          LIMIT $2
+      ) band
+      UNION ALL
+      -- jobs without a priority come last, their aged priority is NULL
+      (SELECT id, due_at, priority FROM jobs
+       WHERE priority IS NULL AND ` + claimableJobs + `
+       ORDER BY due_at
+       LIMIT $2)
+  ), claimed AS (
+      UPDATE jobs
+      SET status = $1, claimed_at = NOW(), attempts = attempts + 1
+      WHERE (id, due_at) IN (
+          SELECT jobs.id, jobs.due_at
+          FROM candidates
+          JOIN jobs ON jobs.id = candidates.id AND jobs.due_at = candidates.due_at
+          -- rechecked on the locked row, another claimer may have taken the job since it was read
+          WHERE jobs.status = 0
+          -- aged priority, see PriorityAging: one level up per aging interval of the band spent past due_at
+          ORDER BY candidates.priority - COALESCE(FLOOR(
+                EXTRACT(EPOCH FROM NOW() - candidates.due_at) /
+                NULLIF(($3::INTEGER[])[candidates.priority + 1], 0)
+            ), 0), candidates.due_at
+          LIMIT $2
+          FOR UPDATE OF jobs SKIP LOCKED
+      )
+      RETURNING id, due_at, COALESCE(priority, 0) AS priority, delivery_id, attempts, expires_at, trace_parent,
+          tenant_id, tenant_type, sequence_id
+  ), events AS (
+      INSERT INTO job_events (job_id, from_status, to_status, attempt, actor, reason)
+      SELECT id, 0, $1, attempts, $4, '` + reasonClaimed + `' FROM claimed
+  )
+  SELECT id, due_at, priority, delivery_id, attempts, expires_at, trace_parent, tenant_id, tenant_type,
+      sequence_id FROM claimed`
+
+// ClaimDueJobs claims in the claim mode of the store. acquired is false when another replica holds the
+// advisory lock, it is always true in skip_locked mode.
+func (p *Postgres) ClaimDueJobs(ctx context.Context, batchSize int, aging common.PriorityAging) (jobs []entity.Job, acquired bool, err error) {
+    if p.options.ClaimMode == ClaimModeSkipLocked {
+        jobs, err = p.claimDueJobs(ctx, p.db, batchSize, aging)
+        return jobs, err == nil, err
+    }
+
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, false, err
+    }
+    defer tx.Rollback()
+
+    if err = tx.QueryRowContext(ctx, `SELECT PG_TRY_ADVISORY_XACT_LOCK($1)`, p.options.LockKey).Scan(&acquired); err != nil || !acquired {
+        return nil, false, err
+    }
+    if jobs, err = p.claimDueJobs(ctx, tx, batchSize, aging); err != nil {
+        // rolled back, nothing was claimed
+        return nil, true, err
+    }
+    return jobs, true, tx.Commit()
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (p *Postgres) claimDueJobs(ctx context.Context, q queryer, batchSize int, aging common.PriorityAging) ([]entity.Job, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    rows, err := q.QueryContext(ctx, ClaimDueJobsQuery, entity.JobStatusInProgress, batchSize, pq.Array(aging.IntervalSeconds()), p.options.Actor)
+    if err != nil {
+        return nil, err
+    }
+    return extractJobs(rows)
+}
+
+// extractJobs reads the claimed jobs, on error it returns the jobs read so far along with it.
+func extractJobs(rows *sql.Rows) ([]entity.Job, error) {
+    defer rows.Close()
+    var jobs []entity.Job
+    for rows.Next() {
+        job := entity.Job{Status: entity.JobStatusInProgress}
+        var expiresAt sql.NullTime
+        var traceParent, tenantType, sequenceId sql.NullString
+        var tenantId sql.NullInt64
+        if err := rows.Scan(&job.Id, &job.DueAt, &job.Priority, &job.DeliveryId, &job.Attempts, &expiresAt, &traceParent,
+            &tenantId, &tenantType, &sequenceId); err != nil {
+            return jobs, fmt.Errorf("error scanning row: %w", err)
+        }
+        if expiresAt.Valid {
+            job.ExpiresAt = &expiresAt.Time
+        }
+        job.TraceParent = traceParent.String
+        job.TenantId = int(tenantId.Int64)
+        job.TenantType = entity.TenantType(tenantType.String)
+        job.SequenceId = sequenceId.String
+        jobs = append(jobs, job)
+    }
+    if err := rows.Err(); err != nil {
+        return jobs, err
+    }
+    return jobs, rows.Close()
+}
+
+// EarliestPendingDueAtQuery is served by the partial index jobs_pending_due_at_index (due_at) WHERE status = 0, the
+// status literal (0 = JobStatusInitialized) must stay in the query for the planner to match the index predicate.
+const EarliestPendingDueAtQuery = `
+  SELECT due_at FROM jobs
+  WHERE status = 0
+    AND (expires_at IS NULL OR expires_at > NOW())
+    AND NOT EXISTS (
+        SELECT 1 FROM job_dependencies
+        WHERE job_dependencies.job_id = jobs.id AND NOT job_dependencies.satisfied
+    )
+  ORDER BY due_at
+  LIMIT 1`
+
+func (p *Postgres) EarliestPendingDueAt(ctx context.Context) (*time.Time, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    var dueAt sql.NullTime
+    err := p.db.QueryRowContext(ctx, EarliestPendingDueAtQuery).Scan(&dueAt)
+    if err == sql.ErrNoRows || (err == nil && !dueAt.Valid) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &dueAt.Time, nil
+}
+
+func (p *Postgres) DeliveredIds(ctx context.Context, deliveryIds []string) (map[string]bool, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    rows, err := p.db.QueryContext(ctx, `SELECT delivery_id FROM job_deliveries WHERE delivery_id = ANY($1::UUID[])`, pq.Array(deliveryIds))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    delivered := map[string]bool{}
+    for rows.Next() {
+        var deliveryId string
+        if err = rows.Scan(&deliveryId); err != nil {
+            return nil, err
+        }
+        delivered[deliveryId] = true
+    }
+    return delivered, rows.Err()
+}
+
+// CompleteJobs writes the delivery records, marks the jobs completed and releases their dependents in one
+// transaction, so a job is never completed without its delivery being recorded or the other way around.
+// The deliveries of stale jobs are recorded too, they were sent.
+func (p *Postgres) CompleteJobs(ctx context.Context, jobs []entity.Job, reason string) ([]int, error) {
+    ids := make([]int, 0, len(jobs))
+    deliveryIds := make([]string, 0, len(jobs))
+    attempts := make([]int, 0, len(jobs))
+    for _, job := range jobs {
+        ids = append(ids, job.Id)
+        deliveryIds = append(deliveryIds, job.DeliveryId)
+        attempts = append(attempts, job.Attempts)
+    }
+
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    // another replica may have recorded the same delivery when the job was sent twice
+    _, err = tx.ExecContext(ctx, `
+      INSERT INTO job_deliveries (delivery_id, job_id, attempt)
+      SELECT * FROM UNNEST($1::UUID[], $2::INTEGER[], $3::INTEGER[])
+      ON CONFLICT (delivery_id) DO NOTHING`, pq.Array(deliveryIds), pq.Array(ids), pq.Array(attempts))
+    if err != nil {
+        return nil, err
+    }
+    stale, err := p.transitionClaimedJobs(ctx, tx, jobTransition{To: entity.JobStatusCompleted, Reason: reason}, jobs)
+    if err != nil {
+        return nil, err
+    }
+    completed := slices.DeleteFunc(ids, func(id int) bool { return slices.Contains(stale, id) })
+    _, err = tx.ExecContext(ctx, `
+      UPDATE job_dependencies SET satisfied = TRUE
+      WHERE depends_on_job_id = ANY($1) AND NOT satisfied`, pq.Array(completed))
+    if err != nil {
+        return nil, err
+    }
+    return stale, tx.Commit()
+}
+
+func (p *Postgres) FailJobs(ctx context.Context, jobs []entity.Job, reason string) ([]int, error) {
+    return p.transitionClaimedJobs(ctx, p.db, jobTransition{To: entity.JobStatusFailed, Reason: reason}, jobs)
+}
+
+func (p *Postgres) ExpireJobs(ctx context.Context, jobs []entity.Job, reason string) ([]int, error) {
+    return p.transitionClaimedJobs(ctx, p.db, jobTransition{To: entity.JobStatusExpired, Reason: reason}, jobs)
+}
+
+func (p *Postgres) ReleaseJobs(ctx context.Context, jobs []entity.Job) (int64, error) {
+    stale, err := p.transitionClaimedJobs(ctx, p.db, jobTransition{To: entity.JobStatusInitialized, Reason: reasonReleased}, jobs)
+    if err != nil {
+        return 0, err
+    }
+    return int64(len(jobs) - len(stale)), nil
+}
+
+// transitionClaimedJobs moves the jobs still in progress under the attempt they were claimed with to t.To, as
+// transitionJobs does, and returns the ids of the others. A claim goes stale when the job fixer requeues the job
+// after the processing timeout, the job may be claimed again since and is left as it is.
+func (p *Postgres) transitionClaimedJobs(ctx context.Context, q queryer, t jobTransition, jobs []entity.Job) ([]int, error) {
+    ids := make([]int, 0, len(jobs))
+    attempts := make([]int, 0, len(jobs))
+    for _, job := range jobs {
+        ids = append(ids, job.Id)
+        attempts = append(attempts, job.Attempts)
+    }
+
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    query := transitionQuery(t, `
+      SELECT id, due_at, status FROM jobs
+      WHERE (id, attempts) IN (SELECT * FROM UNNEST($4::INTEGER[], $5::INTEGER[])) AND status = $6`)
+    rows, err := q.QueryContext(ctx, query+` RETURNING job_id`,
+        p.transitionArgs(t, []interface{}{pq.Array(ids), pq.Array(attempts), entity.JobStatusInProgress})...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    moved := map[int]bool{}
+    for rows.Next() {
+        var id int
+        if err = rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        moved[id] = true
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    var stale []int
+    for _, job := range jobs {
+        if !moved[job.Id] {
+            stale = append(stale, job.Id)
+        }
+    }
+    return stale, rows.Close()
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+    "context"
+    "fmt"
+    "go-pg-bench/entity"
+    "log/slog"
+    "time"
+)
+
+// ArchiveJobs deletes the completed jobs claimed before their retention, or due before it for the jobs claimed
+// before claimed_at was recorded, each statement in its own transaction.
+// We should archive completed jobs instead of deleting them
+// But this is testing code, so we just delete them
+func (p *Postgres) ArchiveJobs(ctx context.Context, retention Retention) (Archived, error) {
+    var archived Archived
+    var err error
+    archived.Jobs, err = p.exec(ctx, `
+      DELETE FROM jobs
+      WHERE status = $1 AND COALESCE(claimed_at, due_at) < NOW() - MAKE_INTERVAL(secs => $2)`,
+        entity.JobStatusCompleted, retention.Jobs.Seconds())
+    if err != nil {
+        return archived, fmt.Errorf("failed to delete completed jobs: %w", err)
+    }
+    archived.Deliveries, err = p.exec(ctx, `DELETE FROM job_deliveries WHERE delivered_at < NOW() - MAKE_INTERVAL(secs => $1)`,
+        retention.Deliveries.Seconds())
+    if err != nil {
+        return archived, fmt.Errorf("failed to delete old job deliveries: %w", err)
+    }
+    archived.Events, err = p.exec(ctx, `DELETE FROM job_events WHERE created_at < NOW() - MAKE_INTERVAL(secs => $1)`,
+        retention.Events.Seconds())
+    if err != nil {
+        return archived, fmt.Errorf("failed to delete old job events: %w", err)
+    }
+    return archived, nil
+}
+
+func (p *Postgres) AbandonJobs(ctx context.Context, maxAttempts int) (int64, error) {
+    return p.transitionJobs(ctx, p.db, jobTransition{To: entity.JobStatusAbandoned, Reason: abandonReason(maxAttempts)},
+        `SELECT id, due_at, status FROM jobs WHERE status = $4 AND attempts >= $5`,
+        entity.JobStatusFailed, maxAttempts)
+}
+
+func (p *Postgres) ExpireQueuedJobs(ctx context.Context) (int64, error) {
+    return p.transitionJobs(ctx, p.db, jobTransition{To: entity.JobStatusExpired, Reason: reasonExpiredQueued},
+        `SELECT id, due_at, status FROM jobs WHERE status = $4 AND expires_at <= NOW()`,
+        entity.JobStatusInitialized)
+}
+
+// ResolveDependencies satisfies the edges the due-job checker could not, because their dependency was deleted,
+// skipped or completed before the edge was written, and propagates failures: a dependent whose dependency was
+// abandoned, cancelled or expired is cancelled or skipped according to its on_failure.
+func (p *Postgres) ResolveDependencies(ctx context.Context) (int64, error) {
+    var released int64
+    for {
+        satisfied, err := p.exec(ctx, `
+          UPDATE job_dependencies SET satisfied = TRUE
+          WHERE NOT satisfied
+            AND NOT EXISTS (
+                SELECT 1 FROM jobs
+                WHERE jobs.id = job_dependencies.depends_on_job_id AND jobs.status <> ALL($1)
+            )`,
+            statusArray(doneStatuses),
+        )
+        if err != nil {
+            return released, err
+        }
+        released += satisfied
+
+        propagated := int64(0)
+        for _, outcome := range dependencyOutcomes {
+            affected, err := p.transitionJobs(ctx, p.db, jobTransition{To: outcome.status, Reason: reasonDependencyDead}, `
+              SELECT id, due_at, status FROM jobs
+              WHERE status = $4
+                AND id IN (
+                    SELECT job_dependencies.job_id FROM job_dependencies
+                    JOIN jobs dependency ON dependency.id = job_dependencies.depends_on_job_id
+                    WHERE NOT job_dependencies.satisfied
+                      AND job_dependencies.on_failure = $5
+                      AND dependency.status = ANY($6)
+                )`,
+                entity.JobStatusInitialized,
+                outcome.onFailure,
+                statusArray(deadStatuses),
+            )
+            if err != nil {
+                return released, err
+            }
+            propagated += affected
+        }
+        if propagated == 0 {
+            break
+        }
+        slog.Info("Cancelled or skipped dependent jobs", "jobs", propagated)
+    }
+
+    // edges of jobs that will not run anymore are not needed
+    _, err := p.exec(ctx, `
+      DELETE FROM job_dependencies
+      WHERE NOT EXISTS (
+          SELECT 1 FROM jobs
+          WHERE jobs.id = job_dependencies.job_id AND jobs.status = ANY($1)
+      )`,
+        statusArray(pendingStatuses),
+    )
+    return released, err
+}
+
+// RequeueStuckJobs requeues the jobs with the same delivery id, it measures the processing time from claimed_at,
+// or from due_at for jobs claimed before claimed_at was recorded. Each statement is its own transaction, the
+// abandoned jobs are left out of the requeue by their attempts.
+func (p *Postgres) RequeueStuckJobs(ctx context.Context, maxProcessingTime time.Duration, maxAttempts int) (int64, int64, error) {
+    const stuckJobs = `
+      SELECT id, due_at, status FROM jobs
+      WHERE status = $4 AND NOW() - COALESCE(jobs.claimed_at, jobs.due_at) > MAKE_INTERVAL(secs => $5)`
+    abandoned, err := p.transitionJobs(ctx, p.db, jobTransition{To: entity.JobStatusAbandoned, Reason: abandonReason(maxAttempts)},
+        stuckJobs+` AND attempts >= $6`,
+        entity.JobStatusInProgress, maxProcessingTime.Seconds(), maxAttempts)
+    if err != nil {
+        return 0, 0, err
+    }
+    requeued, err := p.transitionJobs(ctx, p.db, jobTransition{To: entity.JobStatusInitialized, Reason: reasonTimedOut, Set: "due_at = NOW()"},
+        stuckJobs+` AND attempts < $6`,
+        entity.JobStatusInProgress, maxProcessingTime.Seconds(), maxAttempts)
+    return requeued, abandoned, err
+}
+
+func (p *Postgres) RetryFailedJobs(ctx context.Context) (int64, error) {
+    return p.transitionJobs(ctx, p.db, jobTransition{To: entity.JobStatusInitialized, Reason: reasonRetrying, Set: "due_at = NOW()"},
+        `SELECT id, due_at, status FROM jobs WHERE status = $4`,
+        entity.JobStatusFailed)
+}
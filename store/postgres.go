@@ -0,0 +1,568 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/lib/pq"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "strings"
+    "time"
+)
+
+// insertParamsCount is the number of columns in the query of insertJobBatch
+const insertParamsCount = 11
+
+// PostgresOptions configures the Postgres store of a service, zero values suit the services that do not use them.
+type PostgresOptions struct {
+    // Actor identifies the service in job events, see common.ActorName
+    Actor string
+    // BatchParameters bounds the parameters of one insert, Postgres supports up to 65535
+    BatchParameters int
+    // ClaimMode and LockKey tell how replicas of the due-job checker share the due jobs, see ClaimMode
+    ClaimMode ClaimMode
+    LockKey   int
+}
+
+// Postgres is the JobStore of the services, on the schema of common/migrations.
+type Postgres struct {
+    db      *sql.DB
+    options PostgresOptions
+}
+
+var _ JobStore = (*Postgres)(nil)
+
+// NewPostgres validates the claim mode, an empty mode defaults to ClaimModeSkipLocked.
+func NewPostgres(db *sql.DB, options PostgresOptions) (*Postgres, error) {
+    if options.ClaimMode == "" {
+        options.ClaimMode = ClaimModeSkipLocked
+    }
+    if options.ClaimMode != ClaimModeSkipLocked && options.ClaimMode != ClaimModeAdvisoryXactLock {
+        return nil, fmt.Errorf("unsupported claim mode %q, expected %q or %q", options.ClaimMode, ClaimModeSkipLocked, ClaimModeAdvisoryXactLock)
+    }
+    if options.BatchParameters <= 0 {
+        options.BatchParameters = 65535
+    }
+    return &Postgres{db: db, options: options}, nil
+}
+
+// ClaimMode returns the claim mode in use.
+func (p *Postgres) ClaimMode() ClaimMode {
+    return p.options.ClaimMode
+}
+
+// InsertJobs inserts the jobs in batches of at most BatchParameters query parameters, all batches in one transaction.
+func (p *Postgres) InsertJobs(ctx context.Context, jobTemplates []entity.Job, sequence entity.Sequence) (int, error) {
+    jobTemplateCount := len(jobTemplates)
+    totalJobs := jobTemplateCount * sequence.Subscribers
+    if totalJobs == 0 {
+        return 0, nil
+    }
+    batchSize := max(p.options.BatchParameters/insertParamsCount, 1)
+
+    // Dependencies are resolved once per template, before inserting, so a job never waits on a job of the same request
+    dependencies := make([][]int, jobTemplateCount)
+    var err error
+    for jobIndex, job := range jobTemplates {
+        if dependencies[jobIndex], err = p.resolveDependencies(ctx, job.Dependencies); err != nil {
+            return 0, fmt.Errorf("failed to resolve job dependencies: %w", err)
+        }
+    }
+
+    // Every batch goes in one transaction: a request that fails leaves no jobs behind for its retry to duplicate,
+    // and the due-job checker never sees a job before the edges holding it back
+    tx, err := p.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+    for batchSizeIndex := 0; batchSizeIndex < totalJobs; batchSizeIndex += batchSize {
+        endBatchIndex := min(batchSizeIndex+batchSize, totalJobs)
+        if err = p.insertJobBatch(ctx, tx, jobTemplates, sequence, dependencies, batchSizeIndex, endBatchIndex); err != nil {
+            return 0, err
+        }
+    }
+    if err = tx.Commit(); err != nil {
+        return 0, err
+    }
+    return totalJobs, nil
+}
+
+// insertJobBatch inserts the jobs between batchSizeIndex and endBatchIndex along with their dependency edges and
+// creation events in tx, each statement under the query timeout.
+func (p *Postgres) insertJobBatch(ctx context.Context, tx *sql.Tx, jobTemplates []entity.Job, sequence entity.Sequence, dependencies [][]int, batchSizeIndex int, endBatchIndex int) error {
+    jobTemplateCount := len(jobTemplates)
+
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+
+    // Ids are allocated up front to know which job each dependency edge belongs to
+    ids, err := nextJobIds(ctx, tx, endBatchIndex-batchSizeIndex)
+    if err != nil {
+        return err
+    }
+
+    var query strings.Builder
+    query.WriteString("INSERT INTO jobs (id, due_at, status, priority, metadata, expires_at, sequence_id, subscriber, trace_parent, tenant_id, tenant_type) VALUES ")
+
+    var placeholders []string
+    var args []interface{}
+    var edgeJobIds, edgeDependsOnIds []int
+    var edgeOnFailure []string
+
+    // Create a placeholder for each job and append its values to the args slice
+    for batchItemIndex := batchSizeIndex; batchItemIndex < endBatchIndex; batchItemIndex++ {
+        // Cycle through the jobs array for each subscriber
+        jobIndex := batchItemIndex % jobTemplateCount
+        subscriber := batchItemIndex / jobTemplateCount
+        job := jobTemplates[jobIndex]
+        id := ids[batchItemIndex-batchSizeIndex]
+
+        // Calculate placeholder indexes for SQL query
+        placeholderStartIndex := (batchItemIndex-batchSizeIndex)*insertParamsCount + 1
+        placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+            placeholderStartIndex, placeholderStartIndex+1, placeholderStartIndex+2, placeholderStartIndex+3,
+            placeholderStartIndex+4, placeholderStartIndex+5, placeholderStartIndex+6, placeholderStartIndex+7,
+            placeholderStartIndex+8, placeholderStartIndex+9, placeholderStartIndex+10))
+
+        // Append job details to args slice for query execution
+        args = append(args, id, job.DueAt, job.Status, job.Priority, nullIfEmpty(job.Metadata), job.ExpiresAt,
+            nullIfEmpty(sequence.Id), subscriber, nullIfEmpty(sequence.TraceParent), tenantIdOf(job),
+            nullIfEmpty(string(job.TenantType)))
+
+        for _, dependsOnId := range dependencies[jobIndex] {
+            edgeJobIds = append(edgeJobIds, id)
+            edgeDependsOnIds = append(edgeDependsOnIds, dependsOnId)
+            edgeOnFailure = append(edgeOnFailure, string(onFailure(job.Dependencies)))
+        }
+    }
+
+    query.WriteString(strings.Join(placeholders, ", "))
+    if _, err = tx.ExecContext(ctx, query.String(), args...); err != nil {
+        return err
+    }
+
+    _, err = tx.ExecContext(ctx, `
+      INSERT INTO job_events (job_id, to_status, actor, reason)
+      SELECT UNNEST($1::INTEGER[]), $2, $3, $4`, pq.Array(ids), entity.JobStatusInitialized, p.options.Actor, reasonScheduled)
+    if err != nil {
+        return err
+    }
+
+    if len(edgeJobIds) > 0 {
+        _, err = tx.ExecContext(ctx, `
+          INSERT INTO job_dependencies (job_id, depends_on_job_id, on_failure)
+          SELECT * FROM UNNEST($1::INTEGER[], $2::INTEGER[], $3::VARCHAR[])
+          ON CONFLICT DO NOTHING`, pq.Array(edgeJobIds), pq.Array(edgeDependsOnIds), pq.Array(edgeOnFailure))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func nextJobIds(ctx context.Context, tx *sql.Tx, count int) ([]int, error) {
+    rows, err := tx.QueryContext(ctx, `SELECT NEXTVAL('public.jobs_id_seq') FROM GENERATE_SERIES(1, $1)`, count)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    ids := make([]int, 0, count)
+    for rows.Next() {
+        var id int
+        if err = rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// resolveDependencies returns the ids of the jobs a new job has to wait for. Jobs matching depends_on_metadata that
+// are already completed or skipped are left out, a depends_on job that is done already or does not exist fails
+// the insert.
+func (p *Postgres) resolveDependencies(ctx context.Context, spec entity.JobDependencySpec) ([]int, error) {
+    if spec.IsEmpty() {
+        return nil, nil
+    }
+
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    rows, err := p.db.QueryContext(ctx, `
+      SELECT id FROM jobs
+      WHERE (id = ANY($1) OR metadata @> $2)
+        AND status <> ALL($3)`,
+        pq.Array(spec.DependsOn),
+        nullIfEmpty(spec.DependsOnMetadata),
+        statusArray(doneStatuses),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var ids []int
+    for rows.Next() {
+        var id int
+        if err = rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    return ids, unresolvedDependencies(spec, ids)
+}
+
+// jobColumns are read by scanJob, in this order
+const jobColumns = `id, due_at, priority, tenant_id, status, metadata, delivery_id, attempts, claimed_at, expires_at, sequence_id, subscriber, trace_parent, tenant_type`
+
+// FindJobs reads pages with keyset pagination: pass the NextCursor of a page as filter.After to read the next one.
+// The metadata filter relies on the GIN index on jobs.metadata through the @> containment operator.
+func (p *Postgres) FindJobs(ctx context.Context, filter JobFilter) (JobPage, error) {
+    limit := filter.limit()
+
+    var conditions []string
+    var args []interface{}
+    where := func(condition string, values ...interface{}) {
+        placeholders := make([]interface{}, 0, len(values))
+        for _, value := range values {
+            args = append(args, value)
+            placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+        }
+        conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+    }
+
+    if len(filter.Statuses) > 0 {
+        where("status = ANY(%s)", statusArray(filter.Statuses))
+    }
+    if filter.TenantId != 0 {
+        where("tenant_id = %s", filter.TenantId)
+    }
+    if filter.SequenceId != "" {
+        where("sequence_id = %s", filter.SequenceId)
+    }
+    if filter.Subscriber != nil {
+        where("subscriber = %s", *filter.Subscriber)
+    }
+    if filter.DueFrom != nil {
+        where("due_at >= %s", *filter.DueFrom)
+    }
+    if filter.DueTo != nil {
+        where("due_at < %s", *filter.DueTo)
+    }
+    if filter.MetadataValue != nil {
+        metadata, err := json.Marshal(metadataFilter(filter.MetadataKey, *filter.MetadataValue))
+        if err != nil {
+            return JobPage{}, err
+        }
+        where("metadata @> %s", string(metadata))
+    } else if filter.MetadataKey != "" {
+        where("metadata ? %s", filter.MetadataKey)
+    }
+    if filter.After != nil {
+        where("(due_at, id) > (%s, %s)", filter.After.DueAt, filter.After.Id)
+    }
+
+    query := `SELECT ` + jobColumns + ` FROM jobs`
+    if len(conditions) > 0 {
+        query += ` WHERE ` + strings.Join(conditions, " AND ")
+    }
+    // one extra row tells whether there is a next page
+    args = append(args, limit+1)
+    query += fmt.Sprintf(` ORDER BY due_at, id LIMIT $%d`, len(args))
+
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    rows, err := p.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return JobPage{}, err
+    }
+    defer rows.Close()
+
+    jobs := make([]entity.Job, 0)
+    for rows.Next() {
+        job, err := scanJob(rows)
+        if err != nil {
+            return JobPage{}, err
+        }
+        jobs = append(jobs, job)
+    }
+    if err = rows.Err(); err != nil {
+        return JobPage{}, err
+    }
+    return newJobPage(jobs, limit), nil
+}
+
+func (p *Postgres) GetJob(ctx context.Context, id int) (*JobDetails, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    job, err := scanJob(p.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, ErrJobNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    details := &JobDetails{Job: job, DependsOn: make([]int, 0), Deliveries: make([]entity.JobDelivery, 0)}
+
+    rows, err := p.db.QueryContext(ctx, `SELECT depends_on_job_id FROM job_dependencies WHERE job_id = $1 ORDER BY depends_on_job_id`, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var dependsOn int
+        if err = rows.Scan(&dependsOn); err != nil {
+            return nil, err
+        }
+        details.DependsOn = append(details.DependsOn, dependsOn)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    deliveryRows, err := p.db.QueryContext(ctx, `
+      SELECT delivery_id, attempt, delivered_at
+      FROM job_deliveries
+      WHERE job_id = $1
+      ORDER BY delivered_at`, id)
+    if err != nil {
+        return nil, err
+    }
+    defer deliveryRows.Close()
+    for deliveryRows.Next() {
+        var delivery entity.JobDelivery
+        if err = deliveryRows.Scan(&delivery.DeliveryId, &delivery.Attempt, &delivery.DeliveredAt); err != nil {
+            return nil, err
+        }
+        details.Deliveries = append(details.Deliveries, delivery)
+    }
+    if err = deliveryRows.Err(); err != nil {
+        return nil, err
+    }
+
+    if details.Events, err = p.jobEvents(ctx, id); err != nil {
+        return nil, err
+    }
+    return details, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (entity.Job, error) {
+    var job entity.Job
+    var metadata, sequenceId, traceParent, tenantType sql.NullString
+    var claimedAt, expiresAt sql.NullTime
+    var subscriber sql.NullInt64
+    var priority, tenantId, status sql.NullInt64
+    err := row.Scan(&job.Id, &job.DueAt, &priority, &tenantId, &status, &metadata, &job.DeliveryId, &job.Attempts,
+        &claimedAt, &expiresAt, &sequenceId, &subscriber, &traceParent, &tenantType)
+    if err != nil {
+        return entity.Job{}, err
+    }
+    job.Priority = int(priority.Int64)
+    job.TenantId = int(tenantId.Int64)
+    job.Status = entity.JobStatus(status.Int64)
+    job.Metadata = metadata.String
+    job.SequenceId = sequenceId.String
+    job.Subscriber = int(subscriber.Int64)
+    job.TraceParent = traceParent.String
+    job.TenantType = entity.TenantType(tenantType.String)
+    if claimedAt.Valid {
+        job.ClaimedAt = &claimedAt.Time
+    }
+    if expiresAt.Valid {
+        job.ExpiresAt = &expiresAt.Time
+    }
+    return job, nil
+}
+
+func (p *Postgres) GetJobEvents(ctx context.Context, jobId int) ([]entity.JobEvent, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    var exists bool
+    if err := p.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1)`, jobId).Scan(&exists); err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, ErrJobNotFound
+    }
+    return p.jobEvents(ctx, jobId)
+}
+
+// jobEvents reads the events of a job, oldest first, an empty list for a job without any.
+func (p *Postgres) jobEvents(ctx context.Context, jobId int) ([]entity.JobEvent, error) {
+    rows, err := p.db.QueryContext(ctx, `
+      SELECT id, from_status, to_status, attempt, actor, reason, created_at
+      FROM job_events
+      WHERE job_id = $1
+      ORDER BY id`, jobId)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    events := make([]entity.JobEvent, 0)
+    for rows.Next() {
+        var event entity.JobEvent
+        var fromStatus sql.NullInt64
+        var reason sql.NullString
+        if err = rows.Scan(&event.Id, &fromStatus, &event.ToStatus, &event.Attempt, &event.Actor, &reason, &event.CreatedAt); err != nil {
+            return nil, err
+        }
+        if fromStatus.Valid {
+            status := entity.JobStatus(fromStatus.Int64)
+            event.FromStatus = &status
+        }
+        event.Reason = reason.String
+        events = append(events, event)
+    }
+    return events, rows.Err()
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// jobTransition is a status change applied by transitionJobs.
+type jobTransition struct {
+    To     entity.JobStatus
+    Reason string
+    // Set holds extra assignments made along with the status, e.g. "due_at = NOW()"
+    Set string
+}
+
+// transitionJobs moves the jobs returned by selectJobs to t.To and records a job event for each of them in the
+// same statement. selectJobs must select id, due_at and status from the jobs table under its own name, the rows
+// are locked with FOR UPDATE OF jobs; its placeholders start at $4. It returns the number of jobs moved.
+func (p *Postgres) transitionJobs(ctx context.Context, q execer, t jobTransition, selectJobs string, args ...interface{}) (int64, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    res, err := q.ExecContext(ctx, transitionQuery(t, selectJobs), p.transitionArgs(t, args)...)
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+// transitionQuery is the statement of transitionJobs, it inserts one job event per job moved.
+func transitionQuery(t jobTransition, selectJobs string) string {
+    set := ""
+    if t.Set != "" {
+        set = ", " + t.Set
+    }
+    return fmt.Sprintf(`
+      WITH previous AS (
+          %s
+          FOR UPDATE OF jobs
+      ), changed AS (
+          UPDATE jobs SET status = $1%s
+          FROM previous
+          WHERE jobs.id = previous.id AND jobs.due_at = previous.due_at
+          RETURNING jobs.id, previous.status, jobs.attempts
+      )
+      INSERT INTO job_events (job_id, from_status, to_status, attempt, actor, reason)
+      SELECT id, status, $1, attempts, $2, $3 FROM changed`, selectJobs, set)
+}
+
+// transitionArgs returns the arguments of transitionQuery, args of selectJobs last.
+func (p *Postgres) transitionArgs(t jobTransition, args []interface{}) []interface{} {
+    return append([]interface{}{t.To, p.options.Actor, nullIfEmpty(t.Reason)}, args...)
+}
+
+// exec runs one statement under the deadline of a query, each statement of a fixer pass gets its own.
+func (p *Postgres) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    res, err := p.db.ExecContext(ctx, query, args...)
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+func (p *Postgres) CountQueuedJobs(ctx context.Context) (int, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    var count int
+    err := p.db.QueryRowContext(ctx, `
+      SELECT COUNT(id) AS count
+      FROM jobs
+      WHERE status = $1`, entity.JobStatusInitialized).Scan(&count)
+    return count, err
+}
+
+func (p *Postgres) OldestOverdueJobs(ctx context.Context) (map[int]time.Duration, error) {
+    ctx, cancel := common.WithQueryTimeout(ctx)
+    defer cancel()
+    rows, err := p.db.QueryContext(ctx, `
+      SELECT COALESCE(priority, 0), EXTRACT(EPOCH FROM NOW() - MIN(due_at))
+      FROM jobs
+      WHERE status = $1 AND due_at <= NOW()
+        AND (expires_at IS NULL OR expires_at > NOW())
+        AND NOT EXISTS (
+            SELECT 1 FROM job_dependencies
+            WHERE job_dependencies.job_id = jobs.id AND NOT job_dependencies.satisfied
+        )
+      GROUP BY COALESCE(priority, 0)`, entity.JobStatusInitialized)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    oldest := map[int]time.Duration{}
+    for rows.Next() {
+        var priority int
+        var seconds float64
+        if err = rows.Scan(&priority, &seconds); err != nil {
+            return nil, err
+        }
+        oldest[priority] = time.Duration(seconds * float64(time.Second))
+    }
+    return oldest, rows.Err()
+}
+
+// metadataFilter returns the JSON object a job's metadata must contain to match key and value.
+// The value is compared as JSON when it parses as such, e.g. 42 or true, and as a string otherwise.
+func metadataFilter(key string, value string) map[string]interface{} {
+    var jsonValue interface{} = value
+    var parsed interface{}
+    if err := json.Unmarshal([]byte(value), &parsed); err == nil {
+        jsonValue = parsed
+    }
+    return map[string]interface{}{key: jsonValue}
+}
+
+// onFailure returns the dependency failure policy of spec, cancel by default
+func onFailure(spec entity.JobDependencySpec) entity.DependencyFailurePolicy {
+    if spec.OnDependencyFailure == "" {
+        return entity.DependencyFailureCancel
+    }
+    return spec.OnDependencyFailure
+}
+
+func statusArray(statuses []entity.JobStatus) interface{} {
+    values := make([]int, 0, len(statuses))
+    for _, status := range statuses {
+        values = append(values, int(status))
+    }
+    return pq.Array(values)
+}
+
+// nullIfEmpty maps an empty string to NULL, for columns such as JSONB or UUID that reject it
+func nullIfEmpty(value string) interface{} {
+    if value == "" {
+        return nil
+    }
+    return value
+}
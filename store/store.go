@@ -0,0 +1,253 @@
+// Package store keeps the jobs, their dependency edges, deliveries and events behind JobStore.
+// Postgres is the implementation the services run on, Memory keeps everything in the process with the same
+// semantics, for tests and for running without a database.
+package store
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// JobStore is the storage of the scheduling pipeline: the api-server inserts and reads jobs, the due-job checker
+// claims due jobs and records how their dispatch went, the job fixer archives, requeues and resolves what is left.
+// Every method records a job event for each status change it makes, under the actor of the store.
+type JobStore interface {
+    // InsertJobs inserts jobTemplates once per subscriber of sequence, along with their dependency edges and
+    // creation events, an UnresolvedDependenciesError when a depends_on job is not pending. The jobs are inserted
+    // all at once or not at all: on error, also when ctx is done, none are kept and it returns 0.
+    InsertJobs(ctx context.Context, jobTemplates []entity.Job, sequence entity.Sequence) (int, error)
+    // FindJobs returns a page of jobs matching the filter, ordered by due_at then id.
+    FindJobs(ctx context.Context, filter JobFilter) (JobPage, error)
+    // GetJob returns a job with the jobs it depends on, its recorded deliveries and its events, ErrJobNotFound when
+    // there is none.
+    GetJob(ctx context.Context, id int) (*JobDetails, error)
+    // GetJobEvents returns the events of a job, oldest first, ErrJobNotFound when there is no such job.
+    GetJobEvents(ctx context.Context, jobId int) ([]entity.JobEvent, error)
+
+    // ClaimDueJobs marks at most batchSize due and unexpired jobs not waiting on a dependency as in progress and returns them,
+    // in order of aged priority then due_at. acquired is false when another claimer holds the claim lock.
+    // On error the jobs read before the failure are returned, they are claimed already.
+    ClaimDueJobs(ctx context.Context, batchSize int, aging common.PriorityAging) (jobs []entity.Job, acquired bool, err error)
+    // EarliestPendingDueAt returns the due_at of the next initialized and unexpired job not waiting on a dependency,
+    // nil when there is none.
+    EarliestPendingDueAt(ctx context.Context) (*time.Time, error)
+    // DeliveredIds returns those of deliveryIds with a recorded delivery.
+    DeliveredIds(ctx context.Context, deliveryIds []string) (map[string]bool, error)
+    // CompleteJobs records the deliveries of jobs, marks them completed and satisfies the dependency edges
+    // waiting on them, all or nothing.
+    // Like FailJobs, ExpireJobs and ReleaseJobs it only changes the jobs still in progress under the attempt they
+    // were claimed with, and returns the ids of the others as stale: the job fixer requeued them after the processing
+    // timeout and another claim may own them now.
+    CompleteJobs(ctx context.Context, jobs []entity.Job, reason string) (stale []int, err error)
+    // FailJobs marks jobs that could not be sent as failed, the job fixer retries them.
+    FailJobs(ctx context.Context, jobs []entity.Job, reason string) (stale []int, err error)
+    // ExpireJobs marks jobs claimed after their expires_at as expired.
+    ExpireJobs(ctx context.Context, jobs []entity.Job, reason string) (stale []int, err error)
+    // ReleaseJobs returns the claimed jobs that were not sent to initialized and returns how many were.
+    ReleaseJobs(ctx context.Context, jobs []entity.Job) (int64, error)
+
+    // ArchiveJobs removes the completed jobs, deliveries and events older than their retention.
+    ArchiveJobs(ctx context.Context, retention Retention) (Archived, error)
+    // AbandonJobs gives up on the failed jobs that were attempted maxAttempts times.
+    AbandonJobs(ctx context.Context, maxAttempts int) (int64, error)
+    // ExpireQueuedJobs marks the initialized jobs past their expires_at as expired, the claim leaves them out.
+    ExpireQueuedJobs(ctx context.Context) (int64, error)
+    // ResolveDependencies satisfies the edges of dependencies that are done or gone and cancels or skips the
+    // dependents of abandoned, cancelled or expired jobs, level by level down the graph. It returns the number of
+    // edges satisfied.
+    ResolveDependencies(ctx context.Context) (int64, error)
+    // RequeueStuckJobs returns the jobs in progress for longer than maxProcessingTime to initialized, due now.
+    // Those already attempted maxAttempts times are abandoned instead, as each claim counts an attempt a job that
+    // always times out would be claimed forever otherwise.
+    RequeueStuckJobs(ctx context.Context, maxProcessingTime time.Duration, maxAttempts int) (requeued int64, abandoned int64, err error)
+    // RetryFailedJobs returns the failed jobs to initialized, due now.
+    RetryFailedJobs(ctx context.Context) (int64, error)
+
+    // CountQueuedJobs returns the number of initialized jobs.
+    CountQueuedJobs(ctx context.Context) (int, error)
+    // OldestOverdueJobs returns how long the oldest claimable overdue job of each priority has been waiting,
+    // priorities without one are left out.
+    OldestOverdueJobs(ctx context.Context) (map[int]time.Duration, error)
+}
+
+// Retention tells ArchiveJobs how long completed jobs, deliveries and events are kept.
+type Retention struct {
+    // Jobs is how long completed jobs are kept after they were claimed, at least Events so that a job outlives its
+    // history
+    Jobs time.Duration
+    // Deliveries only need to outlive the window in which a job can be sent twice
+    Deliveries time.Duration
+    Events     time.Duration
+}
+
+// Archived counts what ArchiveJobs removed.
+type Archived struct {
+    Jobs       int64
+    Deliveries int64
+    Events     int64
+}
+
+const (
+    DefaultFindJobsLimit = 100
+    MaxFindJobsLimit     = 1000
+)
+
+// ErrJobNotFound is returned by GetJob when no job has the given id
+var ErrJobNotFound = errors.New("job not found")
+
+// UnresolvedDependenciesError is returned by InsertJobs when jobs listed in depends_on do not exist or are done
+// already, nothing is inserted then. A job waiting on a mistyped id would otherwise run right away.
+type UnresolvedDependenciesError struct {
+    JobIds []int
+}
+
+func (e *UnresolvedDependenciesError) Error() string {
+    ids := make([]string, 0, len(e.JobIds))
+    for _, id := range e.JobIds {
+        ids = append(ids, strconv.Itoa(id))
+    }
+    return "depends_on jobs " + strings.Join(ids, ", ") + " do not exist or are done already"
+}
+
+// unresolvedDependencies returns an UnresolvedDependenciesError for the ids of depends_on missing from resolved
+func unresolvedDependencies(spec entity.JobDependencySpec, resolved []int) error {
+    var missing []int
+    for _, id := range spec.DependsOn {
+        if !slices.Contains(resolved, id) && !slices.Contains(missing, id) {
+            missing = append(missing, id)
+        }
+    }
+    if len(missing) > 0 {
+        return &UnresolvedDependenciesError{JobIds: missing}
+    }
+    return nil
+}
+
+// JobFilter narrows down FindJobs, zero values do not filter.
+type JobFilter struct {
+    Statuses   []entity.JobStatus
+    TenantId   int
+    SequenceId string
+    Subscriber *int
+    DueFrom    *time.Time // inclusive
+    DueTo      *time.Time // exclusive
+    // MetadataKey matches the jobs whose metadata has the key, MetadataValue further requires its value when set
+    MetadataKey   string
+    MetadataValue *string
+    After         *JobCursor
+    Limit         int
+}
+
+// limit returns the page size of the filter, DefaultFindJobsLimit when unset and at most MaxFindJobsLimit
+func (f JobFilter) limit() int {
+    if f.Limit <= 0 {
+        return DefaultFindJobsLimit
+    }
+    return min(f.Limit, MaxFindJobsLimit)
+}
+
+// JobCursor is the keyset position of a job in the (due_at, id) order of FindJobs.
+type JobCursor struct {
+    DueAt time.Time
+    Id    int
+}
+
+// Encode returns the cursor in the opaque form passed back as the cursor query parameter.
+func (c JobCursor) Encode() string {
+    return base64.RawURLEncoding.EncodeToString([]byte(c.DueAt.UTC().Format(time.RFC3339Nano) + "," + strconv.Itoa(c.Id)))
+}
+
+func ParseJobCursor(cursor string) (JobCursor, error) {
+    raw, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return JobCursor{}, errors.New("invalid cursor")
+    }
+    dueAt, id, found := strings.Cut(string(raw), ",")
+    if !found {
+        return JobCursor{}, errors.New("invalid cursor")
+    }
+    var c JobCursor
+    if c.DueAt, err = time.Parse(time.RFC3339Nano, dueAt); err != nil {
+        return JobCursor{}, errors.New("invalid cursor")
+    }
+    if c.Id, err = strconv.Atoi(id); err != nil {
+        return JobCursor{}, errors.New("invalid cursor")
+    }
+    return c, nil
+}
+
+// JobPage is a page of FindJobs, NextCursor is empty on the last page.
+type JobPage struct {
+    Jobs       []entity.Job `json:"jobs"`
+    NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// newJobPage returns the page of jobs read with one extra row, which tells whether there is a next page
+func newJobPage(jobs []entity.Job, limit int) JobPage {
+    page := JobPage{Jobs: jobs}
+    if len(page.Jobs) > limit {
+        page.Jobs = page.Jobs[:limit]
+        last := page.Jobs[limit-1]
+        page.NextCursor = JobCursor{DueAt: last.DueAt, Id: last.Id}.Encode()
+    }
+    return page
+}
+
+// JobDetails is the full record of a job returned by GET /jobs/{id}.
+type JobDetails struct {
+    entity.Job
+    // DependsOn lists the jobs this one still waits for or waited for
+    DependsOn  []int                `json:"depends_on"`
+    Deliveries []entity.JobDelivery `json:"deliveries"`
+    // Events are the status changes of the job, oldest first, as returned by GetJobEvents
+    Events []entity.JobEvent `json:"events"`
+}
+
+// Reasons recorded in the job events, shared by the implementations
+const (
+    reasonScheduled      = "scheduled"
+    reasonClaimed        = "claimed"
+    reasonReleased       = "released unsent"
+    reasonTimedOut       = "processing timed out"
+    reasonRetrying       = "retrying after failure"
+    reasonDependencyDead = "dependency abandoned, cancelled or expired"
+    reasonExpiredQueued  = "expired before it was claimed"
+)
+
+// doneStatuses are the statuses of a dependency its dependents stop waiting for
+var doneStatuses = []entity.JobStatus{entity.JobStatusCompleted, entity.JobStatusSkipped}
+
+// deadStatuses are the statuses of a dependency that cancel or skip its dependents
+var deadStatuses = []entity.JobStatus{entity.JobStatusAbandoned, entity.JobStatusCancelled, entity.JobStatusExpired}
+
+// pendingStatuses are the statuses of a job still to be processed
+var pendingStatuses = []entity.JobStatus{entity.JobStatusInitialized, entity.JobStatusInProgress, entity.JobStatusFailed}
+
+func abandonReason(maxAttempts int) string {
+    return "failed " + strconv.Itoa(maxAttempts) + " attempts"
+}
+
+// dependencyOutcomes are the statuses dependents of a dead dependency move to, by failure policy.
+// Cancel comes first: it wins over skip when a job has failed dependencies of both kinds.
+var dependencyOutcomes = []struct {
+    onFailure entity.DependencyFailurePolicy
+    status    entity.JobStatus
+}{
+    {entity.DependencyFailureCancel, entity.JobStatusCancelled},
+    {entity.DependencyFailureSkip, entity.JobStatusSkipped},
+}
+
+// tenantIdOf returns the tenant of a job template, entity.DefaultTenantId when it has none
+func tenantIdOf(job entity.Job) int {
+    if job.TenantId == 0 {
+        return entity.DefaultTenantId
+    }
+    return job.TenantId
+}
@@ -0,0 +1,223 @@
+package tests
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "github.com/lib/pq"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// BenchmarkClaimDueJobs measures the claim query against a real database, on the partitioned jobs table and on a
+// plain copy of it side by side, both at the schema version the database is migrated to. data-feed can only reach
+// the partitioned table through the api-server, so BENCH_FEED_JOBS_PER_SECOND stands in for it and inserts jobs
+// into the benchmarked table while claiming:
+//
+//    TEST_POSTGRES_CONNECTION_STRING=postgres://... go test -run ^$ -bench ClaimDueJobs ./store/tests
+func BenchmarkClaimDueJobs(b *testing.B) {
+    for _, table := range []string{"partitioned", "plain"} {
+        b.Run("table="+table, func(b *testing.B) {
+            conn := openBenchJobsTable(b, table)
+            batchSize := testEnvInt("BENCH_CLAIM_BATCH_SIZE", 2000)
+            seedBenchJobs(b, conn, testEnvInt("BENCH_JOB_COUNT", 200000))
+            feedBenchJobs(b, conn, testEnvInt("BENCH_FEED_JOBS_PER_SECOND", 0))
+            jobStore, err := store.NewPostgres(conn, store.PostgresOptions{Actor: benchActor})
+            if err != nil {
+                b.Fatal(err)
+            }
+
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                jobs, _, err := jobStore.ClaimDueJobs(context.Background(), batchSize, benchPriorityAging)
+                if err != nil {
+                    b.Fatal(err)
+                }
+
+                // put the batch back so every iteration claims from the same amount of due jobs
+                b.StopTimer()
+                ids := make([]int, 0, len(jobs))
+                for _, job := range jobs {
+                    ids = append(ids, job.Id)
+                }
+                if _, err = conn.Exec(`UPDATE jobs SET status = $1 WHERE id = ANY($2)`, entity.JobStatusInitialized, pq.Array(ids)); err != nil {
+                    b.Fatal(err)
+                }
+                b.StartTimer()
+            }
+        })
+    }
+}
+
+// plainJobsSchema holds the plain copy of the jobs table BenchmarkClaimDueJobs compares the partitioned one with
+const plainJobsSchema = "claim_bench_plain"
+
+// openBenchJobsTable returns a connection on which jobs is the partitioned table of public, or for "plain" a
+// table with the same columns, defaults and indexes, the claim index among them, that is not partitioned.
+// The other tables the claim query touches stay those of public.
+func openBenchJobsTable(b *testing.B, table string) *sql.DB {
+    conn := openTestDB(b)
+    if table != "plain" {
+        return conn
+    }
+    setup := []string{
+        `DROP SCHEMA IF EXISTS ` + plainJobsSchema + ` CASCADE`,
+        `CREATE SCHEMA ` + plainJobsSchema,
+        `CREATE TABLE ` + plainJobsSchema + `.jobs (LIKE public.jobs INCLUDING DEFAULTS INCLUDING INDEXES)`,
+    }
+    for _, query := range setup {
+        if _, err := conn.Exec(query); err != nil {
+            b.Fatal(err)
+        }
+    }
+    b.Cleanup(func() {
+        if _, err := conn.Exec(`DROP SCHEMA ` + plainJobsSchema + ` CASCADE`); err != nil {
+            b.Error(err)
+        }
+    })
+    return openTestDBInSchema(b, plainJobsSchema)
+}
+
+// benchActor is the actor of the job events the benchmarks write, they are deleted along with the jobs
+const benchActor = "bench"
+
+// benchPriorityAging ages every band, so the benchmarks pay for computing the aged priority
+var benchPriorityAging = common.PriorityAging{Intervals: map[entity.TenantType]time.Duration{
+    entity.TenantTypeNew:        time.Minute,
+    entity.TenantTypeSme:        time.Minute,
+    entity.TenantTypeEnterprise: time.Minute,
+}}
+
+// seedBenchJobs replaces earlier benchmark rows with jobs due within +/- 15 days,
+// so both past and future partitions are populated.
+func seedBenchJobs(b *testing.B, conn *sql.DB, count int) {
+    if err := deleteBenchJobs(conn); err != nil {
+        b.Fatal(err)
+    }
+    _, err := conn.Exec(`
+      INSERT INTO jobs (due_at, status, priority, metadata)
+      SELECT NOW() + (RANDOM() * 30 - 15) * INTERVAL '1 day', $1, (RANDOM() * 3)::INTEGER, '{"bench": true}'
+      FROM GENERATE_SERIES(1, $2)`, entity.JobStatusInitialized, count)
+    if err != nil {
+        b.Fatal(err)
+    }
+    if _, err = conn.Exec(`ANALYZE jobs`); err != nil {
+        b.Fatal(err)
+    }
+    b.Cleanup(func() {
+        if err := deleteBenchJobs(conn); err != nil {
+            b.Error(err)
+        }
+    })
+}
+
+// deleteBenchJobs deletes the benchmark jobs and the claim events written for them, job_events stays in public
+// for the plain copy of jobs too.
+func deleteBenchJobs(conn *sql.DB) error {
+    if _, err := conn.Exec(`DELETE FROM jobs WHERE metadata @> '{"bench": true}'`); err != nil {
+        return err
+    }
+    _, err := conn.Exec(`DELETE FROM job_events WHERE actor = $1`, benchActor)
+    return err
+}
+
+// feedBenchJobs inserts perSecond jobs a second until the benchmark ends, in the shape data-feed schedules them:
+// one step due now and one a minute later. Nothing is inserted when perSecond is 0.
+func feedBenchJobs(b *testing.B, conn *sql.DB, perSecond int) {
+    if perSecond <= 0 {
+        return
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    // registered after seedBenchJobs, so the feed stops before the benchmark jobs are deleted
+    b.Cleanup(func() {
+        cancel()
+        <-done
+    })
+
+    go func() {
+        defer close(done)
+        ticker := time.NewTicker(100 * time.Millisecond)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+            }
+            _, err := conn.ExecContext(ctx, `
+              INSERT INTO jobs (due_at, status, priority, metadata)
+              SELECT NOW() + (step % 2) * INTERVAL '1 minute', $1, (RANDOM() * 3)::INTEGER, '{"bench": true}'
+              FROM GENERATE_SERIES(1, $2) step`, entity.JobStatusInitialized, max(perSecond/10, 1))
+            if err != nil && ctx.Err() == nil {
+                b.Error(err)
+                return
+            }
+        }
+    }()
+}
+
+// BenchmarkClaimReplicas drains the same backlog with 1..8 concurrent claimers per claim mode and reports
+// claimed jobs per second, showing how each mode scales horizontally.
+func BenchmarkClaimReplicas(b *testing.B) {
+    conn := openTestDB(b)
+    batchSize := testEnvInt("BENCH_CLAIM_BATCH_SIZE", 2000)
+    jobCount := testEnvInt("BENCH_JOB_COUNT", 200000)
+
+    for _, mode := range []store.ClaimMode{store.ClaimModeSkipLocked, store.ClaimModeAdvisoryXactLock} {
+        for _, replicas := range []int{1, 2, 4, 8} {
+            b.Run(fmt.Sprintf("%s/replicas=%d", mode, replicas), func(b *testing.B) {
+                jobStore, err := store.NewPostgres(conn, store.PostgresOptions{Actor: benchActor, ClaimMode: mode, LockKey: testEnvInt("JOB_CHECKER_LOCK_KEY", 1)})
+                if err != nil {
+                    b.Fatal(err)
+                }
+
+                var claimed int64
+                var elapsed time.Duration
+                for i := 0; i < b.N; i++ {
+                    b.StopTimer()
+                    seedBenchJobs(b, conn, jobCount)
+                    b.StartTimer()
+
+                    start := time.Now()
+                    claimed += drainWithReplicas(b, jobStore, replicas, batchSize)
+                    elapsed += time.Since(start)
+                }
+                b.ReportMetric(float64(claimed)/elapsed.Seconds(), "jobs/s")
+            })
+        }
+    }
+}
+
+// drainWithReplicas claims with concurrent claimers until no due job is left and returns the number claimed.
+func drainWithReplicas(b *testing.B, jobStore store.JobStore, replicas int, batchSize int) int64 {
+    var claimed int64
+    var wg sync.WaitGroup
+    for r := 0; r < replicas; r++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                jobs, acquired, err := jobStore.ClaimDueJobs(context.Background(), batchSize, benchPriorityAging)
+                if err != nil {
+                    b.Error(err)
+                    return
+                }
+                if !acquired {
+                    continue
+                }
+                if len(jobs) == 0 {
+                    return
+                }
+                atomic.AddInt64(&claimed, int64(len(jobs)))
+            }
+        }()
+    }
+    wg.Wait()
+    return claimed
+}
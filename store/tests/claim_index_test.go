@@ -0,0 +1,132 @@
+package tests
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "github.com/lib/pq"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "strings"
+    "testing"
+)
+
+// TestClaimQueryUsesClaimIndex fails when the claim query stops being served by jobs_claim_index, or sorts what it
+// reads from it instead of taking the due jobs of each band out in index order, e.g. after a change to the query
+// or to the index definition.
+func TestClaimQueryUsesClaimIndex(t *testing.T) {
+    tx := beginPlanFixture(t)
+    expectIndexOrder(t, tx, "jobs_claim_index",
+        store.ClaimDueJobsQuery, entity.JobStatusInProgress, 100, pq.Array([]int{60}), "test")
+}
+
+// TestEarliestPendingDueAtUsesPendingIndex fails when the query the due-job checker sleeps on stops reading the
+// earliest initialized job off jobs_pending_due_at_index, every empty poll would scan the finished jobs then.
+func TestEarliestPendingDueAtUsesPendingIndex(t *testing.T) {
+    tx := beginPlanFixture(t)
+    expectIndexOrder(t, tx, "jobs_pending_due_at_index", store.EarliestPendingDueAtQuery)
+}
+
+// beginPlanFixture opens a transaction holding mostly finished jobs with more due ones than a batch, the shape the
+// partial indexes are built for, with statistics the planner costs a sequential scan against. It is rolled back
+// when the test ends.
+func beginPlanFixture(t *testing.T) *sql.Tx {
+    conn := openTestDB(t)
+    if err := common.RequireSchemaVersion(context.Background(), conn); err != nil {
+        t.Fatal(err)
+    }
+
+    tx, err := conn.Begin()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { tx.Rollback() })
+
+    setup := []string{
+        `INSERT INTO jobs (due_at, status, priority)
+         SELECT NOW() - RANDOM() * INTERVAL '1 hour', 2, (RANDOM() * 3)::INTEGER FROM GENERATE_SERIES(1, 200000)`,
+        `INSERT INTO jobs (due_at, status, priority)
+         SELECT NOW() - RANDOM() * INTERVAL '1 hour', 0, (RANDOM() * 3)::INTEGER FROM GENERATE_SERIES(1, 2000)`,
+        `ANALYZE jobs`,
+    }
+    for _, query := range setup {
+        if _, err = tx.Exec(query); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return tx
+}
+
+// expectIndexOrder runs EXPLAIN on query and fails unless it reads index, or a partition's copy of it, without
+// sorting what it reads.
+func expectIndexOrder(t *testing.T, tx *sql.Tx, index string, query string, args ...interface{}) {
+    // the index itself on a plain table, or the per-partition indexes attached to it on the partitioned one
+    indexes := map[string]bool{index: true}
+    rows, err := tx.Query(`
+      SELECT child.relname
+      FROM pg_inherits
+      JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+      WHERE pg_inherits.inhparent = ('public.' || $1)::REGCLASS`, index)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for rows.Next() {
+        var name string
+        if err = rows.Scan(&name); err != nil {
+            t.Fatal(err)
+        }
+        indexes[name] = true
+    }
+    if err = rows.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    var rawPlan []byte
+    if err = tx.QueryRow(`EXPLAIN (FORMAT JSON) `+query, args...).Scan(&rawPlan); err != nil {
+        t.Fatal(err)
+    }
+    var plan interface{}
+    if err = json.Unmarshal(rawPlan, &plan); err != nil {
+        t.Fatal(err)
+    }
+
+    scans := map[string]bool{}
+    planIndexScans(plan, false, scans)
+    var read bool
+    for name, sorted := range scans {
+        if !indexes[name] {
+            continue
+        }
+        read = true
+        if sorted {
+            t.Errorf("query sorts what it reads from %s instead of reading it in index order\n%s", name, rawPlan)
+        }
+    }
+    if !read {
+        t.Errorf("query does not use %s, indexes in plan: %v\n%s", index, scans, rawPlan)
+    }
+}
+
+// planIndexScans records in scans every "Index Name" of an EXPLAIN (FORMAT JSON) plan, true when a Sort reads its
+// rows before any Limit does, i.e. when they are sorted rather than taken out in index order.
+func planIndexScans(node interface{}, sorted bool, scans map[string]bool) {
+    switch n := node.(type) {
+    case map[string]interface{}:
+        nodeType, _ := n["Node Type"].(string)
+        if strings.Contains(nodeType, "Sort") {
+            sorted = true
+        } else if nodeType == "Limit" {
+            sorted = false
+        }
+        if name, ok := n["Index Name"].(string); ok {
+            scans[name] = scans[name] || sorted
+        }
+        planIndexScans(n["Plan"], sorted, scans)
+        planIndexScans(n["Plans"], sorted, scans)
+    case []interface{}:
+        for _, value := range n {
+            planIndexScans(value, sorted, scans)
+        }
+    }
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+    "database/sql"
+    "github.com/lib/pq"
+    "os"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+// openTestDB connects to the database named by TEST_POSTGRES_CONNECTION_STRING and skips when it is not set.
+func openTestDB(tb testing.TB) *sql.DB {
+    connStr := os.Getenv("TEST_POSTGRES_CONNECTION_STRING")
+    if connStr == "" {
+        tb.Skip("TEST_POSTGRES_CONNECTION_STRING is not set")
+    }
+    conn, err := sql.Open("postgres", connStr)
+    if err != nil {
+        tb.Fatal(err)
+    }
+    tb.Cleanup(func() { conn.Close() })
+    if err = conn.Ping(); err != nil {
+        tb.Fatal(err)
+    }
+    return conn
+}
+
+// openTestDBInSchema connects like openTestDB with schema ahead of public on the search_path, so its tables shadow
+// those of public with the same name.
+func openTestDBInSchema(tb testing.TB, schema string) *sql.DB {
+    connStr := os.Getenv("TEST_POSTGRES_CONNECTION_STRING")
+    if connStr == "" {
+        tb.Skip("TEST_POSTGRES_CONNECTION_STRING is not set")
+    }
+    if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+        var err error
+        if connStr, err = pq.ParseURL(connStr); err != nil {
+            tb.Fatal(err)
+        }
+    }
+    // unknown connection parameters are sent to the server as settings of the session
+    conn, err := sql.Open("postgres", connStr+" search_path="+schema+",public")
+    if err != nil {
+        tb.Fatal(err)
+    }
+    tb.Cleanup(func() { conn.Close() })
+    if err = conn.Ping(); err != nil {
+        tb.Fatal(err)
+    }
+    return conn
+}
+
+func testEnvInt(key string, fallback int) int {
+    if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+        return v
+    }
+    return fallback
+}
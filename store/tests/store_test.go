@@ -0,0 +1,581 @@
+package tests
+
+import (
+    "context"
+    "errors"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "slices"
+    "testing"
+    "time"
+)
+
+func TestJobCursorRoundTrip(t *testing.T) {
+    cursor := store.JobCursor{DueAt: time.Date(2024, 1, 2, 12, 30, 0, 123456000, time.UTC), Id: 42}
+
+    got, err := store.ParseJobCursor(cursor.Encode())
+    if err != nil {
+        t.Fatalf("ParseJobCursor() error = %v", err)
+    }
+    if !got.DueAt.Equal(cursor.DueAt) || got.Id != cursor.Id {
+        t.Errorf("ParseJobCursor() = %+v, want %+v", got, cursor)
+    }
+
+    for _, invalid := range []string{"not base64!", "bm8gY29tbWE", "MjAyNC0wMS0wMlQxMjozMDowMFosYQ"} {
+        if _, err = store.ParseJobCursor(invalid); err == nil {
+            t.Errorf("ParseJobCursor(%q) expected error, got nil", invalid)
+        }
+    }
+}
+
+func TestMemoryStore(t *testing.T) {
+    testJobStore(t, func(t *testing.T) store.JobStore {
+        return store.NewMemory("test")
+    })
+}
+
+// TestPostgresStore runs the same cases against Postgres, it empties the job tables of the test database.
+func TestPostgresStore(t *testing.T) {
+    conn := openTestDB(t)
+    if err := common.RequireSchemaVersion(context.Background(), conn); err != nil {
+        t.Fatal(err)
+    }
+    testJobStore(t, func(t *testing.T) store.JobStore {
+        if _, err := conn.Exec(`TRUNCATE jobs, job_dependencies, job_deliveries, job_events`); err != nil {
+            t.Fatal(err)
+        }
+        jobStore, err := store.NewPostgres(conn, store.PostgresOptions{Actor: "test"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        return jobStore
+    })
+}
+
+// TestPostgresInsertJobsAllOrNothing fails a request in its second batch, the first one must not be kept for a retry
+// of the request to insert again.
+func TestPostgresInsertJobsAllOrNothing(t *testing.T) {
+    conn := openTestDB(t)
+    if err := common.RequireSchemaVersion(context.Background(), conn); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := conn.Exec(`TRUNCATE jobs, job_dependencies, job_deliveries, job_events`); err != nil {
+        t.Fatal(err)
+    }
+    // a single parameter per batch still inserts one job per batch
+    jobStore, err := store.NewPostgres(conn, store.PostgresOptions{Actor: "test", BatchParameters: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx := context.Background()
+    templates := []entity.Job{
+        {DueAt: time.Now().UTC(), Status: entity.JobStatusInitialized, Metadata: `{"campaign": 42}`},
+        {DueAt: time.Now().UTC(), Status: entity.JobStatusInitialized, Metadata: `not json`},
+    }
+    inserted, err := jobStore.InsertJobs(ctx, templates, entity.Sequence{Id: testSequenceId, Subscribers: 1})
+    if err == nil || inserted != 0 {
+        t.Fatalf("InsertJobs() with invalid metadata in the second batch = %d, %v, want 0 and an error", inserted, err)
+    }
+    page, err := jobStore.FindJobs(ctx, store.JobFilter{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(page.Jobs) != 0 {
+        t.Errorf("FindJobs() after a failed InsertJobs() = %d jobs, want none", len(page.Jobs))
+    }
+}
+
+const testSequenceId = "00000000-0000-0000-0000-000000000001"
+
+// testJobStore walks a JobStore through the pipeline of the services: the api-server inserts, the due-job checker
+// claims and records the dispatch, the job fixer cleans up. newStore returns an empty store for each case.
+func testJobStore(t *testing.T, newStore func(t *testing.T) store.JobStore) {
+    ctx := context.Background()
+    // Postgres keeps microseconds
+    now := time.Now().UTC().Truncate(time.Second)
+    aging := common.PriorityAging{Intervals: map[entity.TenantType]time.Duration{
+        entity.TenantTypeNew:        time.Minute,
+        entity.TenantTypeSme:        time.Minute,
+        entity.TenantTypeEnterprise: time.Minute,
+    }}
+
+    t.Run("Insert and find", func(t *testing.T) {
+        jobStore := newStore(t)
+        templates := []entity.Job{
+            {DueAt: now.Add(-time.Minute), Status: entity.JobStatusInitialized, Metadata: `{"campaign": 42}`},
+            {DueAt: now.Add(time.Hour), Status: entity.JobStatusInitialized, Metadata: `{"campaign": "summer"}`},
+        }
+        inserted, err := jobStore.InsertJobs(ctx, templates, entity.Sequence{Id: testSequenceId, Subscribers: 2})
+        if err != nil || inserted != 4 {
+            t.Fatalf("InsertJobs() = %d, %v, want 4 jobs", inserted, err)
+        }
+
+        first, err := jobStore.FindJobs(ctx, store.JobFilter{SequenceId: testSequenceId, Limit: 3})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(first.Jobs) != 3 || first.NextCursor == "" {
+            t.Fatalf("FindJobs() = %d jobs, cursor %q, want 3 jobs and a cursor", len(first.Jobs), first.NextCursor)
+        }
+        cursor, err := store.ParseJobCursor(first.NextCursor)
+        if err != nil {
+            t.Fatal(err)
+        }
+        second, err := jobStore.FindJobs(ctx, store.JobFilter{SequenceId: testSequenceId, After: &cursor, Limit: 3})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(second.Jobs) != 1 || second.NextCursor != "" {
+            t.Fatalf("FindJobs() after cursor = %d jobs, cursor %q, want the last job", len(second.Jobs), second.NextCursor)
+        }
+        jobs := append(first.Jobs, second.Jobs...)
+        for i := 1; i < len(jobs); i++ {
+            if jobs[i].DueAt.Before(jobs[i-1].DueAt) || (jobs[i].DueAt.Equal(jobs[i-1].DueAt) && jobs[i].Id < jobs[i-1].Id) {
+                t.Errorf("FindJobs() jobs out of (due_at, id) order: %+v", jobs)
+            }
+        }
+
+        for value, expected := range map[string]int{"42": 2, "summer": 2, "winter": 0, "": 0} {
+            page, err := jobStore.FindJobs(ctx, store.JobFilter{MetadataKey: "campaign", MetadataValue: &value})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(page.Jobs) != expected {
+                t.Errorf("FindJobs(campaign=%s) = %d jobs, want %d", value, len(page.Jobs), expected)
+            }
+        }
+        for key, expected := range map[string]int{"campaign": 4, "summer": 0} {
+            page, err := jobStore.FindJobs(ctx, store.JobFilter{MetadataKey: key})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(page.Jobs) != expected {
+                t.Errorf("FindJobs(metadata_key=%s) = %d jobs, want %d", key, len(page.Jobs), expected)
+            }
+        }
+        subscriber := 1
+        page, err := jobStore.FindJobs(ctx, store.JobFilter{Subscriber: &subscriber, DueFrom: &now})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(page.Jobs) != 1 || page.Jobs[0].Subscriber != 1 || page.Jobs[0].TenantId != 1 || page.Jobs[0].DeliveryId == "" {
+            t.Errorf("FindJobs(subscriber=1, due_from=now) = %+v, want the later job of subscriber 1", page.Jobs)
+        }
+
+        details, err := jobStore.GetJob(ctx, jobs[0].Id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if details.SequenceId != testSequenceId || len(details.DependsOn) != 0 || len(details.Deliveries) != 0 || len(details.Events) != 1 {
+            t.Errorf("GetJob() = %+v", details)
+        }
+        if _, err = jobStore.GetJob(ctx, jobs[len(jobs)-1].Id+1000); !errors.Is(err, store.ErrJobNotFound) {
+            t.Errorf("GetJob() of a missing job error = %v, want %v", err, store.ErrJobNotFound)
+        }
+        if _, err = jobStore.GetJobEvents(ctx, jobs[len(jobs)-1].Id+1000); !errors.Is(err, store.ErrJobNotFound) {
+            t.Errorf("GetJobEvents() of a missing job error = %v, want %v", err, store.ErrJobNotFound)
+        }
+        expectEvents(t, jobStore, jobs[0].Id, "scheduled")
+    })
+
+    t.Run("Claim carries the tenant", func(t *testing.T) {
+        jobStore := newStore(t)
+        templates := []entity.Job{
+            {DueAt: now.Add(-time.Minute), Status: entity.JobStatusInitialized, TenantId: 7, TenantType: entity.TenantTypeSme},
+        }
+        if _, err := jobStore.InsertJobs(ctx, templates, entity.Sequence{Id: testSequenceId, Subscribers: 1}); err != nil {
+            t.Fatal(err)
+        }
+        jobs, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+        if err != nil || len(jobs) != 1 {
+            t.Fatalf("ClaimDueJobs() = %+v, %v, want the job", jobs, err)
+        }
+        if jobs[0].TenantId != 7 || jobs[0].TenantType != entity.TenantTypeSme {
+            t.Errorf("ClaimDueJobs() tenant = %d %q, want 7 %q", jobs[0].TenantId, jobs[0].TenantType, entity.TenantTypeSme)
+        }
+    })
+
+    t.Run("Claim in aged priority order and complete", func(t *testing.T) {
+        jobStore := newStore(t)
+        insertJob(t, jobStore, entity.Job{DueAt: now.Add(-30 * time.Second), Priority: 2})
+        insertJob(t, jobStore, entity.Job{DueAt: now.Add(-10 * time.Second), Priority: 0})
+        // three aging intervals overdue, priority 2 ages past priority 0
+        aged := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-3 * time.Minute), Priority: 2})
+        insertJob(t, jobStore, entity.Job{DueAt: now.Add(time.Hour)})
+
+        jobs, acquired, err := jobStore.ClaimDueJobs(ctx, 2, aging)
+        if err != nil || !acquired {
+            t.Fatalf("ClaimDueJobs() = %v, %v", acquired, err)
+        }
+        if len(jobs) != 2 || jobs[0].Id != aged.Id || jobs[1].Priority != 0 {
+            t.Fatalf("ClaimDueJobs() = %+v, want the aged job then the priority 0 one", jobs)
+        }
+        if jobs[0].Attempts != 1 || jobs[0].Status != entity.JobStatusInProgress {
+            t.Errorf("ClaimDueJobs() claimed %+v, want attempt 1 in progress", jobs[0])
+        }
+        if remaining, _, _ := jobStore.ClaimDueJobs(ctx, 10, aging); len(remaining) != 1 {
+            t.Errorf("ClaimDueJobs() again = %d jobs, want the last due one", len(remaining))
+        }
+        if queued, err := jobStore.CountQueuedJobs(ctx); err != nil || queued != 1 {
+            t.Errorf("CountQueuedJobs() = %d, %v, want the future job", queued, err)
+        }
+
+        if stale, err := jobStore.CompleteJobs(ctx, jobs, "sent"); err != nil || len(stale) != 0 {
+            t.Fatalf("CompleteJobs() = %v, %v, want no stale job", stale, err)
+        }
+        delivered, err := jobStore.DeliveredIds(ctx, []string{jobs[0].DeliveryId, "00000000-0000-0000-0000-000000000000"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(delivered) != 1 || !delivered[jobs[0].DeliveryId] {
+            t.Errorf("DeliveredIds() = %v, want the delivery of the completed job", delivered)
+        }
+        details, err := jobStore.GetJob(ctx, aged.Id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if details.Status != entity.JobStatusCompleted || len(details.Deliveries) != 1 || details.Deliveries[0].Attempt != 1 {
+            t.Errorf("GetJob() after completion = %+v", details)
+        }
+        if details.Attempts != 1 || details.ClaimedAt == nil {
+            t.Errorf("GetJob() after completion attempts = %d, claimed_at = %v, want the claim", details.Attempts, details.ClaimedAt)
+        }
+        if n := len(details.Events); n != 3 || details.Events[n-1].ToStatus != entity.JobStatusCompleted {
+            t.Errorf("GetJob().Events after completion = %+v, want the history ending in the completion", details.Events)
+        }
+        expectEvents(t, jobStore, aged.Id, "scheduled", "claimed", "sent")
+
+        // completed jobs are kept for their retention, along with their events
+        archived, err := jobStore.ArchiveJobs(ctx, store.Retention{Jobs: time.Hour, Deliveries: time.Hour, Events: time.Hour})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if archived.Jobs != 0 {
+            t.Errorf("ArchiveJobs() within the retention = %+v, want no job", archived)
+        }
+        if details, err = jobStore.GetJob(ctx, aged.Id); err != nil || details.Status != entity.JobStatusCompleted {
+            t.Errorf("GetJob() of a completed job within the retention = %+v, %v", details, err)
+        }
+
+        archived, err = jobStore.ArchiveJobs(ctx, store.Retention{Jobs: 0, Deliveries: 0, Events: time.Hour})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if archived.Jobs != 2 || archived.Deliveries != 2 || archived.Events != 0 {
+            t.Errorf("ArchiveJobs() = %+v, want 2 jobs and their deliveries", archived)
+        }
+        if _, err = jobStore.GetJob(ctx, aged.Id); !errors.Is(err, store.ErrJobNotFound) {
+            t.Errorf("GetJob() of an archived job error = %v, want %v", err, store.ErrJobNotFound)
+        }
+    })
+
+    t.Run("Unknown or done dependencies are rejected", func(t *testing.T) {
+        jobStore := newStore(t)
+        done := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute)})
+        jobs, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+        if err != nil || len(jobs) != 1 {
+            t.Fatalf("ClaimDueJobs() = %+v, %v, want the job", jobs, err)
+        }
+        if stale, err := jobStore.CompleteJobs(ctx, jobs, "sent"); err != nil || len(stale) != 0 {
+            t.Fatalf("CompleteJobs() = %v, %v, want no stale job", stale, err)
+        }
+        pending := insertJob(t, jobStore, entity.Job{DueAt: now.Add(time.Hour)})
+
+        templates := []entity.Job{{DueAt: now, Status: entity.JobStatusInitialized,
+            Dependencies: entity.JobDependencySpec{DependsOn: []int{pending.Id, done.Id, pending.Id + 1000}}}}
+        inserted, err := jobStore.InsertJobs(ctx, templates, entity.Sequence{Id: testSequenceId, Subscribers: 1})
+        var unresolved *store.UnresolvedDependenciesError
+        if !errors.As(err, &unresolved) || inserted != 0 {
+            t.Fatalf("InsertJobs() = %d, %v, want an UnresolvedDependenciesError", inserted, err)
+        }
+        if len(unresolved.JobIds) != 2 || unresolved.JobIds[0] != done.Id || unresolved.JobIds[1] != pending.Id+1000 {
+            t.Errorf("UnresolvedDependenciesError.JobIds = %v, want [%d %d]", unresolved.JobIds, done.Id, pending.Id+1000)
+        }
+        if queued, err := jobStore.CountQueuedJobs(ctx); err != nil || queued != 1 {
+            t.Errorf("CountQueuedJobs() = %d, %v, want only the pending job", queued, err)
+        }
+    })
+
+    t.Run("Dependencies hold jobs back until completed", func(t *testing.T) {
+        jobStore := newStore(t)
+        parent := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute), Metadata: `{"step": "extract"}`})
+        dependent := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute),
+            Dependencies: entity.JobDependencySpec{DependsOnMetadata: `{"step": "extract"}`}})
+        details, err := jobStore.GetJob(ctx, dependent.Id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(details.DependsOn) != 1 || details.DependsOn[0] != parent.Id {
+            t.Errorf("GetJob().DependsOn = %v, want [%d]", details.DependsOn, parent.Id)
+        }
+
+        jobs, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(jobs) != 1 || jobs[0].Id != parent.Id {
+            t.Fatalf("ClaimDueJobs() = %+v, want the parent alone", jobs)
+        }
+        if earliest, err := jobStore.EarliestPendingDueAt(ctx); err != nil || earliest != nil {
+            t.Errorf("EarliestPendingDueAt() = %v, %v, want none while the dependent waits", earliest, err)
+        }
+        if stale, err := jobStore.CompleteJobs(ctx, jobs, "sent"); err != nil || len(stale) != 0 {
+            t.Fatalf("CompleteJobs() = %v, %v, want no stale job", stale, err)
+        }
+        if earliest, err := jobStore.EarliestPendingDueAt(ctx); err != nil || earliest == nil || !earliest.Equal(dependent.DueAt) {
+            t.Errorf("EarliestPendingDueAt() = %v, %v, want %v", earliest, err, dependent.DueAt)
+        }
+        if jobs, _, err = jobStore.ClaimDueJobs(ctx, 10, aging); err != nil || len(jobs) != 1 || jobs[0].Id != dependent.Id {
+            t.Errorf("ClaimDueJobs() after the parent completed = %+v, %v, want the dependent", jobs, err)
+        }
+    })
+
+    t.Run("Failures are retried, abandoned and propagated", func(t *testing.T) {
+        jobStore := newStore(t)
+        parent := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute)})
+        cancelled := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute),
+            Dependencies: entity.JobDependencySpec{DependsOn: []int{parent.Id}}})
+        skipped := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute),
+            Dependencies: entity.JobDependencySpec{DependsOn: []int{parent.Id}, OnDependencyFailure: entity.DependencyFailureSkip}})
+        grandchild := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute),
+            Dependencies: entity.JobDependencySpec{DependsOn: []int{cancelled.Id}}})
+
+        claimAndFail := func(attempt int) {
+            jobs, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+            if err != nil || len(jobs) != 1 || jobs[0].Id != parent.Id || jobs[0].Attempts != attempt {
+                t.Fatalf("ClaimDueJobs() = %+v, %v, want attempt %d of the parent", jobs, err, attempt)
+            }
+            if stale, err := jobStore.FailJobs(ctx, jobs, "send failed: timeout"); err != nil || len(stale) != 0 {
+                t.Fatalf("FailJobs() = %v, %v, want no stale job", stale, err)
+            }
+        }
+        claimAndFail(1)
+        if abandoned, err := jobStore.AbandonJobs(ctx, 2); err != nil || abandoned != 0 {
+            t.Errorf("AbandonJobs() after one attempt = %d, %v, want 0", abandoned, err)
+        }
+        if retried, err := jobStore.RetryFailedJobs(ctx); err != nil || retried != 1 {
+            t.Fatalf("RetryFailedJobs() = %d, %v, want 1", retried, err)
+        }
+        claimAndFail(2)
+        if abandoned, err := jobStore.AbandonJobs(ctx, 2); err != nil || abandoned != 1 {
+            t.Fatalf("AbandonJobs() after two attempts = %d, %v, want 1", abandoned, err)
+        }
+
+        if _, err := jobStore.ResolveDependencies(ctx); err != nil {
+            t.Fatal(err)
+        }
+        for id, status := range map[int]entity.JobStatus{
+            parent.Id:     entity.JobStatusAbandoned,
+            cancelled.Id:  entity.JobStatusCancelled,
+            skipped.Id:    entity.JobStatusSkipped,
+            grandchild.Id: entity.JobStatusCancelled,
+        } {
+            if details, err := jobStore.GetJob(ctx, id); err != nil || details.Status != status {
+                t.Errorf("GetJob(%d) = %+v, %v, want status %v", id, details, err, status)
+            }
+        }
+        expectEvents(t, jobStore, parent.Id, "scheduled", "claimed", "send failed: timeout", "retrying after failure",
+            "claimed", "send failed: timeout", "failed 2 attempts")
+        expectEvents(t, jobStore, grandchild.Id, "scheduled", "dependency abandoned, cancelled or expired")
+    })
+
+    t.Run("Expired jobs are left out of the claim", func(t *testing.T) {
+        jobStore := newStore(t)
+        expiresAt := now.Add(-time.Second)
+        expired := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute), ExpiresAt: &expiresAt})
+        live := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute)})
+
+        jobs, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+        if err != nil || len(jobs) != 1 || jobs[0].Id != live.Id {
+            t.Fatalf("ClaimDueJobs() = %+v, %v, want only the live job", jobs, err)
+        }
+        if earliest, err := jobStore.EarliestPendingDueAt(ctx); err != nil || earliest != nil {
+            t.Errorf("EarliestPendingDueAt() = %v, %v, want none", earliest, err)
+        }
+        if oldest, err := jobStore.OldestOverdueJobs(ctx); err != nil || len(oldest) != 0 {
+            t.Errorf("OldestOverdueJobs() = %v, %v, want none", oldest, err)
+        }
+        if count, err := jobStore.ExpireQueuedJobs(ctx); err != nil || count != 1 {
+            t.Errorf("ExpireQueuedJobs() = %d, %v, want 1", count, err)
+        }
+        details, err := jobStore.GetJob(ctx, expired.Id)
+        if err != nil || details.Status != entity.JobStatusExpired || details.Attempts != 0 {
+            t.Errorf("GetJob() = %+v, %v, want the job expired without an attempt", details, err)
+        }
+        expectEvents(t, jobStore, expired.Id, "scheduled", "expired before it was claimed")
+    })
+
+    t.Run("Stuck jobs out of attempts are abandoned", func(t *testing.T) {
+        jobStore := newStore(t)
+        stuck := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute)})
+        for attempt := 1; attempt <= 2; attempt++ {
+            jobs, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+            if err != nil || len(jobs) != 1 || jobs[0].Attempts != attempt {
+                t.Fatalf("ClaimDueJobs() = %+v, %v, want attempt %d", jobs, err, attempt)
+            }
+            requeued, abandoned, err := jobStore.RequeueStuckJobs(ctx, 0, 2)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if attempt == 1 && (requeued != 1 || abandoned != 0) {
+                t.Errorf("RequeueStuckJobs() after one attempt = %d requeued, %d abandoned, want 1 requeued", requeued, abandoned)
+            }
+            if attempt == 2 && (requeued != 0 || abandoned != 1) {
+                t.Errorf("RequeueStuckJobs() after two attempts = %d requeued, %d abandoned, want 1 abandoned", requeued, abandoned)
+            }
+        }
+        if details, err := jobStore.GetJob(ctx, stuck.Id); err != nil || details.Status != entity.JobStatusAbandoned {
+            t.Errorf("GetJob() = %+v, %v, want the job abandoned", details, err)
+        }
+        expectEvents(t, jobStore, stuck.Id, "scheduled", "claimed", "processing timed out", "claimed", "failed 2 attempts")
+    })
+
+    t.Run("Stuck and unsent jobs are requeued", func(t *testing.T) {
+        jobStore := newStore(t)
+        stuck := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute)})
+        unsent := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute)})
+        expired := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute)})
+        jobs, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+        if err != nil || len(jobs) != 3 {
+            t.Fatalf("ClaimDueJobs() = %d jobs, %v, want 3", len(jobs), err)
+        }
+
+        if released, err := jobStore.ReleaseJobs(ctx, jobsWithIds(jobs, unsent.Id)); err != nil || released != 1 {
+            t.Errorf("ReleaseJobs() = %d, %v, want 1", released, err)
+        }
+        if stale, err := jobStore.ExpireJobs(ctx, jobsWithIds(jobs, expired.Id), "expired before it was sent"); err != nil || len(stale) != 0 {
+            t.Fatalf("ExpireJobs() = %v, %v, want no stale job", stale, err)
+        }
+        if requeued, _, err := jobStore.RequeueStuckJobs(ctx, time.Hour, 5); err != nil || requeued != 0 {
+            t.Errorf("RequeueStuckJobs(1h) = %d, %v, want 0", requeued, err)
+        }
+        if requeued, abandoned, err := jobStore.RequeueStuckJobs(ctx, 0, 5); err != nil || requeued != 1 || abandoned != 0 {
+            t.Errorf("RequeueStuckJobs(0) = %d requeued, %d abandoned, %v, want 1 requeued", requeued, abandoned, err)
+        }
+
+        oldest, err := jobStore.OldestOverdueJobs(ctx)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if overdue := oldest[0]; overdue < time.Minute-time.Second || overdue > time.Hour {
+            t.Errorf("OldestOverdueJobs()[0] = %v, want about the minute the released job is overdue", overdue)
+        }
+
+        jobs, _, err = jobStore.ClaimDueJobs(ctx, 10, aging)
+        if err != nil {
+            t.Fatal(err)
+        }
+        attempts := map[int]int{}
+        for _, job := range jobs {
+            attempts[job.Id] = job.Attempts
+        }
+        if len(jobs) != 2 || attempts[stuck.Id] != 2 || attempts[unsent.Id] != 2 {
+            t.Errorf("ClaimDueJobs() after requeue = %+v, want the stuck and unsent jobs on attempt 2", jobs)
+        }
+        if jobs[0].DeliveryId == "" || jobs[0].DeliveryId == jobs[1].DeliveryId {
+            t.Errorf("ClaimDueJobs() delivery ids = %q, %q, want distinct ones", jobs[0].DeliveryId, jobs[1].DeliveryId)
+        }
+        expectEvents(t, jobStore, stuck.Id, "scheduled", "claimed", "processing timed out", "claimed")
+        expectEvents(t, jobStore, unsent.Id, "scheduled", "claimed", "released unsent", "claimed")
+        expectEvents(t, jobStore, expired.Id, "scheduled", "claimed", "expired before it was sent")
+    })
+
+    t.Run("Stale claims are left unchanged", func(t *testing.T) {
+        jobStore := newStore(t)
+        job := insertJob(t, jobStore, entity.Job{DueAt: now.Add(-time.Minute)})
+        first, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+        if err != nil || len(first) != 1 {
+            t.Fatalf("ClaimDueJobs() = %+v, %v, want the job", first, err)
+        }
+        // the job fixer times the first dispatch out
+        if requeued, _, err := jobStore.RequeueStuckJobs(ctx, 0, 5); err != nil || requeued != 1 {
+            t.Fatalf("RequeueStuckJobs() = %d, %v, want 1", requeued, err)
+        }
+        if stale, err := jobStore.CompleteJobs(ctx, first, "sent"); err != nil || !slices.Equal(stale, []int{job.Id}) {
+            t.Errorf("CompleteJobs() of the requeued job = %v, %v, want it stale", stale, err)
+        }
+
+        // another replica claims and sends it, then the first dispatch reports late
+        second, _, err := jobStore.ClaimDueJobs(ctx, 10, aging)
+        if err != nil || len(second) != 1 || second[0].Attempts != 2 {
+            t.Fatalf("ClaimDueJobs() again = %+v, %v, want attempt 2 of the job", second, err)
+        }
+        if stale, err := jobStore.CompleteJobs(ctx, second, "sent"); err != nil || len(stale) != 0 {
+            t.Fatalf("CompleteJobs() = %v, %v, want no stale job", stale, err)
+        }
+        if stale, err := jobStore.FailJobs(ctx, first, "send failed: timeout"); err != nil || !slices.Equal(stale, []int{job.Id}) {
+            t.Errorf("FailJobs() of the first claim = %v, %v, want it stale", stale, err)
+        }
+        if stale, err := jobStore.ExpireJobs(ctx, first, "expired before it was sent"); err != nil || !slices.Equal(stale, []int{job.Id}) {
+            t.Errorf("ExpireJobs() of the first claim = %v, %v, want it stale", stale, err)
+        }
+        if released, err := jobStore.ReleaseJobs(ctx, first); err != nil || released != 0 {
+            t.Errorf("ReleaseJobs() of the first claim = %d, %v, want 0", released, err)
+        }
+
+        if retried, err := jobStore.RetryFailedJobs(ctx); err != nil || retried != 0 {
+            t.Errorf("RetryFailedJobs() = %d, %v, want 0", retried, err)
+        }
+        if details, err := jobStore.GetJob(ctx, job.Id); err != nil || details.Status != entity.JobStatusCompleted {
+            t.Errorf("GetJob() = %+v, %v, want the job completed", details, err)
+        }
+        expectEvents(t, jobStore, job.Id, "scheduled", "claimed", "processing timed out", "claimed", "sent")
+    })
+}
+
+// jobsWithIds returns the jobs among jobs with one of ids.
+func jobsWithIds(jobs []entity.Job, ids ...int) []entity.Job {
+    var found []entity.Job
+    for _, job := range jobs {
+        if slices.Contains(ids, job.Id) {
+            found = append(found, job)
+        }
+    }
+    return found
+}
+
+// insertJob inserts a single initialized job and returns it as stored.
+func insertJob(t *testing.T, jobStore store.JobStore, job entity.Job) entity.Job {
+    t.Helper()
+    job.Status = entity.JobStatusInitialized
+    if _, err := jobStore.InsertJobs(context.Background(), []entity.Job{job}, entity.Sequence{Subscribers: 1}); err != nil {
+        t.Fatal(err)
+    }
+    page, err := jobStore.FindJobs(context.Background(), store.JobFilter{Limit: store.MaxFindJobsLimit})
+    if err != nil {
+        t.Fatal(err)
+    }
+    stored := page.Jobs[0]
+    for _, candidate := range page.Jobs {
+        if candidate.Id > stored.Id {
+            stored = candidate
+        }
+    }
+    return stored
+}
+
+// expectEvents checks the reasons of the events of a job, in order.
+func expectEvents(t *testing.T, jobStore store.JobStore, jobId int, reasons ...string) {
+    t.Helper()
+    events, err := jobStore.GetJobEvents(context.Background(), jobId)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for _, event := range events {
+        got = append(got, event.Reason)
+    }
+    if len(got) != len(reasons) {
+        t.Fatalf("GetJobEvents(%d) reasons = %q, want %q", jobId, got, reasons)
+    }
+    for i := range got {
+        if got[i] != reasons[i] {
+            t.Fatalf("GetJobEvents(%d) reasons = %q, want %q", jobId, got, reasons)
+        }
+    }
+    if events[0].FromStatus != nil || events[0].Actor != "test" {
+        t.Errorf("GetJobEvents(%d) first event = %+v, want the creation by test", jobId, events[0])
+    }
+}
@@ -1,194 +1,82 @@
 package main
 
 import (
-    "context"
-    "database/sql"
     "github.com/lib/pq"
-    _ "github.com/lib/pq"
-    "github.com/prometheus/client_golang/prometheus"
     . "go-pg-bench/common"
-    "go-pg-bench/entity"
-    "log"
-    "os"
-    "os/signal"
-    "sort"
-    "syscall"
+    "go-pg-bench/config"
+    "go-pg-bench/store"
+    "go-pg-bench/worker-due-job-checker/checker"
+    "log/slog"
+    "net/http"
     "time"
 )
 
-var (
-    collector = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Name: "due_job_checker_metric_collector",
-            Help: "Total delay time from the moment job was due to the moment it was taken out",
-        },
-        []string{"count"},
-    )
-)
-
 func main() {
-    LoadEnv()
-    conn := GetDBConnection()
+    var cfg config.DueJobChecker
+    config.MustLoad(&cfg)
+    SetupLogger("due-job-checker", cfg.Logging)
+    conn := ConnectDB(cfg.Database)
     defer conn.Close()
 
-    prometheus.MustRegister(collector)
-    dueJobBatchSize := GetEnvInt("DUE_JOB_CHECKER_BATCH_SIZE", 1000)
-
-    ctx, cancel := context.WithCancel(context.Background())
-    defer cancel()
-
-    sigs := make(chan os.Signal, 1)
-    signal.Notify(sigs, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-    go func() {
-        <-sigs
-        log.Println("Gracefully shutting down...")
-        cancel()
-    }()
-
-    for {
-        select {
-        case <-ctx.Done():
-            log.Println("Shutting down...")
-            return
-        default:
-            start := time.Now()
-
-            if _, lockErr := conn.Exec(`SELECT PG_ADVISORY_LOCK($1)`, GetEnvInt("JOB_CHECKER_LOCK_KEY", 1)); lockErr != nil {
-                log.Println("Failed to acquire lock", lockErr)
-                continue
-            }
-
-            rows, err := conn.Query(`
-              UPDATE jobs 
-              SET status = $1
-              WHERE id IN (
-                  SELECT id FROM jobs 
-                  WHERE due_at <= NOW() AND status = $2
-                  ORDER BY priority 
-                  LIMIT $3
-                  FOR UPDATE SKIP LOCKED
-              )
-              RETURNING id, due_at`, entity.JobStatusInProgress, entity.JobStatusInitialized, dueJobBatchSize)
-            if err != nil {
-                log.Printf("Failed to update jobs: %v\n", err)
-                continue
-            }
-
-            jobs := extractJobs(rows)
-
-            // Release lock
-            if _, lockErr := conn.Exec(`SELECT PG_ADVISORY_UNLOCK($1)`, GetEnvInt("JOB_CHECKER_LOCK_KEY", 1)); lockErr != nil {
-                log.Println("Failed to release lock", lockErr)
-                continue
-            }
-
-            sendJobsNextService(jobs)
-            collectMetrics(jobs, start)
-        }
+    checker.RegisterMetrics()
+    // metrics, health and readiness are served on DUE_JOB_CHECKER_HTTP_ADDR
+    health := NewHealth(conn, cfg.Health.MaxStall)
+    mux := http.NewServeMux()
+    health.Register(mux)
+    flushMetrics := StartMetrics("due_job_checker", mux, cfg.Metrics)
+    defer flushMetrics()
+    if cfg.HTTPAddr != "" {
+        ServeHTTP(cfg.HTTPAddr, mux)
     }
-}
-
-func collectMetrics(jobs []entity.Job, start time.Time) {
-    if len(jobs) == 0 {
-        return
+    flushTraces := StartTracing("due-job-checker", cfg.Tracing)
+    defer flushTraces()
+
+    jobStore, err := store.NewPostgres(conn, store.PostgresOptions{
+        Actor:     ActorName("due-job-checker"),
+        ClaimMode: store.ClaimMode(cfg.ClaimMode),
+        LockKey:   cfg.LockKey,
+    })
+    if err != nil {
+        Fatal("Invalid claim mode", ErrorAttr(err))
     }
-    processRate := float64(len(jobs)) / time.Now().Sub(start).Seconds()
-    CollectMetric(collector, "job_throughput_per_sec", processRate)
-
-    log.Printf("Processed batch of %d jobs. Total time %dms", len(jobs), time.Since(start).Milliseconds())
-
-    p95 := calculateP95(jobs)
-    CollectMetric(collector, "job_post_process_p95", p95)
-}
-
-func calculateP95(jobs []entity.Job) float64 {
-    if len(jobs) == 0 {
-        // No jobs, so percentile is undefined. Handle as needed.
-        return -1 // or math.NaN()
-    }
-
-    var delays []float64
-    utcNow := time.Now().UTC()
-    for _, job := range jobs {
-        delay := utcNow.Sub(job.DueAt).Milliseconds()
-        delays = append(delays, float64(delay))
+    slog.Info("Claiming due jobs", "claim_mode", jobStore.ClaimMode())
+
+    // On SIGTERM the checker stops claiming, releases claimed batches that were not dispatched yet
+    // and lets the batch in flight finish until the shutdown timeout.
+    ctx, drainCtx := ShutdownContexts(cfg.Shutdown.Timeout)
+    err = checker.Run(ctx, drainCtx, checker.Options{
+        JobStore:            jobStore,
+        ClaimMode:           string(jobStore.ClaimMode()),
+        BatchSize:           cfg.BatchSize,
+        DispatchConcurrency: cfg.DispatchConcurrency,
+        Send:                checker.SendMessageToQueue,
+        MinPollInterval:     cfg.MinPollInterval,
+        MaxPollInterval:     cfg.MaxPollInterval,
+        Aging:               PriorityAging{Intervals: cfg.Aging.Intervals()},
+        Retry:               NewFailurePolicy(cfg.Retry),
+        Notifications:       listenJobsScheduled(cfg.Database.ConnectionString),
+        Notify:              NotifyOn(conn),
+        Health:              health,
+    })
+    if err != nil {
+        Fatal("Giving up", ErrorAttr(err))
     }
-    // We may need to store the delays for later use, so we'll return them
-
-    if len(delays) == 1 {
-        // Only one job, so its delay is the p95
-        return delays[0]
-    }
-
-    sort.Float64s(delays)
-
-    p95Index := int(float64(len(delays)) * 0.95)
-    if p95Index >= len(delays) {
-        p95Index = len(delays) - 1
-    }
-    return delays[p95Index]
+    slog.Info("Shutting down...")
 }
 
-func extractJobs(rows *sql.Rows) []entity.Job {
-    var jobs []entity.Job
-    for rows.Next() {
-        var id int
-        var dueAt time.Time
-        if rsError := rows.Scan(&id, &dueAt); rsError != nil {
-            log.Fatalf("Error scanning row: %v", rsError)
-        }
-        job := entity.Job{
-            Id:    id,
-            DueAt: dueAt,
-        }
-        jobs = append(jobs, job)
-    }
-    if rCError := rows.Close(); rCError != nil {
-        log.Fatal(rCError)
-    }
-    return jobs
-}
-
-func sendJobsNextService(jobs []entity.Job) {
-    if len(jobs) == 0 {
-        return
-    }
-    var completedJobs, failedJobs []int
-
-    for _, job := range jobs {
-        err := sendMessageToQueue(job.Id)
-        if err != nil {
-            failedJobs = append(failedJobs, job.Id)
-        } else {
-            completedJobs = append(completedJobs, job.Id)
-        }
-    }
-
-    // Update completed jobs
-    if len(completedJobs) > 0 {
-        err := updateJobStatuses(completedJobs, entity.JobStatusCompleted)
-        if err != nil {
-            log.Printf("Failed to update completed jobs: %v", err)
-        }
-    }
-
-    // Update failed jobs
-    if len(failedJobs) > 0 {
-        err := updateJobStatuses(failedJobs, entity.JobStatusFailed)
-        if err != nil {
-            log.Printf("Failed to update failed jobs: %v", err)
-        }
+// listenJobsScheduled subscribes to the notifications sent by the api-server and the job fixer.
+// It returns nil when listening is not possible, the checker then relies on polling alone.
+func listenJobsScheduled(connectionString string) <-chan *pq.Notification {
+    listener := pq.NewListener(connectionString, 10*time.Second, time.Minute,
+        func(event pq.ListenerEventType, err error) {
+            if err != nil {
+                slog.Warn("Job notification listener error", ErrorAttr(err))
+            }
+        })
+    if err := listener.Listen(JobsScheduledChannel); err != nil {
+        slog.Warn("Failed to listen for scheduled jobs, falling back to polling", ErrorAttr(err))
+        listener.Close()
+        return nil
     }
-    // track error rate
-    CollectMetric(collector, "job_post_process_error_rate", (float64)(len(failedJobs)/len(jobs)))
-}
-
-func updateJobStatuses(jobIDs []int, status entity.JobStatus) error {
-    conn := GetDBConnection()
-    _, err := conn.Exec(`UPDATE jobs SET status = $1 WHERE id = ANY($2)`, status, pq.Array(jobIDs))
-    return err
-}
-
-func sendMessageToQueue(jobId int) error {
-    return nil
+    return listener.Notify
 }
@@ -0,0 +1,575 @@
+// Package checker claims the due jobs and dispatches them to the next service, see Run.
+package checker
+
+import (
+    "context"
+    "github.com/lib/pq"
+    "github.com/prometheus/client_golang/prometheus"
+    . "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
+    "log/slog"
+    "strconv"
+    "time"
+)
+
+var (
+    jobsClaimed = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "due_job_checker_jobs_claimed_total",
+        Help: "Jobs claimed, including the ones claimed again after a requeue",
+    })
+    jobsDispatched = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "due_job_checker_jobs_dispatched_total",
+        Help: "Jobs sent to the next service",
+    })
+    jobsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "due_job_checker_jobs_failed_total",
+        Help: "Jobs that could not be sent to the next service",
+    })
+    jobsExpired = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "due_job_checker_jobs_expired_total",
+        Help: "Jobs dropped because they were claimed after they expired",
+    })
+    staleClaims = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "due_job_checker_stale_claims_total",
+        Help: "Jobs left unchanged after dispatch because the job fixer requeued them in the meantime",
+    })
+    claimDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+        Name:    "due_job_checker_claim_duration_seconds",
+        Help:    "Duration of the claim query, empty claims included",
+        Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+    })
+    dispatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+        Name:    "due_job_checker_dispatch_duration_seconds",
+        Help:    "Duration of sending one job to the next service",
+        Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+    })
+    dispatchBackpressure = prometheus.NewHistogram(prometheus.HistogramOpts{
+        Name:    "due_job_checker_dispatch_backpressure_seconds",
+        Help:    "Time a claimed batch waited for the dispatcher to take it",
+        Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+    })
+    // Lateness histograms are labelled by tenant type and priority, see latenessLabels
+    jobLateness = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "due_job_checker_job_lateness_seconds",
+        Help:    "Time from the moment a job was due to the moment it was claimed",
+        Buckets: latenessBuckets,
+    }, []string{"tenant_type", "priority"})
+    jobDispatchLateness = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "due_job_checker_job_dispatch_lateness_seconds",
+        Help:    "Time from the moment a job was due to the moment it was sent to the next service",
+        Buckets: latenessBuckets,
+    }, []string{"tenant_type", "priority"})
+    expiredJobLateness = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "due_job_checker_expired_job_lateness_seconds",
+        Help:    "Time from the moment a job was due to the moment it was dropped as expired",
+        Buckets: latenessBuckets,
+    }, []string{"tenant_type", "priority"})
+
+    // 10ms up to about 12 hours, late jobs after an outage still land in a bucket
+    latenessBuckets = prometheus.ExponentialBuckets(0.01, 4, 12)
+)
+
+// Options configures Run.
+type Options struct {
+    JobStore store.JobStore
+    // ClaimMode is reported on the claim spans
+    ClaimMode           string
+    BatchSize           int
+    DispatchConcurrency int
+    // Send publishes a job to the next service, SendMessageToQueue in the services
+    Send            func(message entity.JobMessage) error
+    MinPollInterval time.Duration
+    MaxPollInterval time.Duration
+    Aging           PriorityAging
+    // Retry bounds the retries of the claims and of the status updates after dispatch
+    Retry FailurePolicy
+    // Notifications wake the checker up when a job due sooner gets scheduled, nil to rely on polling alone
+    Notifications <-chan *pq.Notification
+    // Notify announces the jobs released at shutdown to the other replicas
+    Notify NotifyFunc
+    Health *Health
+}
+
+// checker holds what the dispatch of a batch needs besides the batch
+type checker struct {
+    jobStore    store.JobStore
+    retryPolicy FailurePolicy
+    notify      NotifyFunc
+}
+
+// RegisterMetrics registers the metrics of the due-job checker with the default registry.
+func RegisterMetrics() {
+    RegisterWorkerMetrics()
+    prometheus.MustRegister(jobsClaimed, jobsDispatched, jobsFailed, jobsExpired,
+        staleClaims, claimDuration, dispatchDuration, dispatchBackpressure, jobLateness, jobDispatchLateness, expiredJobLateness)
+}
+
+// Run claims due jobs and dispatches them until ctx is done. It then stops claiming, releases claimed batches
+// that were not dispatched yet and lets the batch in flight finish until drainCtx is done.
+// It returns an error when transient failures went on for too long.
+func Run(ctx context.Context, drainCtx context.Context, options Options) error {
+    c := &checker{jobStore: options.JobStore, retryPolicy: options.Retry, notify: options.Notify}
+    health := options.Health
+    dueJobBatchSize := options.BatchSize
+
+    // Dispatch runs in its own goroutine so the next batch is claimed while the current one is in flight.
+    // The batches channel holds a single claimed batch: when dispatch falls behind, the claim loop blocks on it.
+    pool := newDispatchPool(options.DispatchConcurrency, options.Send)
+    batches := make(chan claimedBatch, 1)
+    dispatchDone := make(chan struct{})
+    go func() {
+        defer close(dispatchDone)
+        for batch := range batches {
+            if ctx.Err() != nil {
+                c.releaseJobs(ctx, batch.jobs)
+                continue
+            }
+            c.sendJobsNextService(drainCtx, pool, batch.jobs)
+            slog.Info("Processed batch", "jobs", len(batch.jobs), "duration_ms", time.Since(batch.claimedAt).Milliseconds())
+        }
+    }()
+
+    // Sleep between empty claims, waking up early when a job due sooner gets scheduled
+    waiter := newPollWaiter(options.Notifications)
+    minPollInterval, maxPollInterval := options.MinPollInterval, options.MaxPollInterval
+    emptyClaims := 0
+
+    // Transient failures are retried with backoff, the checker gives up after too many failures in a row
+    failures := NewFailureTracker(options.Retry)
+    var fatalErr error
+
+    for ctx.Err() == nil && fatalErr == nil {
+        start := time.Now()
+
+        claimCtx, claimSpan := Tracer().Start(ctx, "ClaimDueJobs", trace.WithAttributes(
+            attribute.String("claim.mode", options.ClaimMode), attribute.Int("claim.batch_size", dueJobBatchSize)))
+        jobs, acquired, err := c.jobStore.ClaimDueJobs(claimCtx, dueJobBatchSize, options.Aging)
+        claimSpan.SetAttributes(attribute.Bool("claim.acquired", acquired), attribute.Int("claim.jobs", len(jobs)))
+        EndSpan(claimSpan, err)
+        if err != nil {
+            // rows read before the failure are claimed already
+            if len(jobs) > 0 {
+                c.releaseJobs(ctx, jobs)
+            }
+            if ctx.Err() != nil {
+                // the claim was cancelled by the shutdown, not a failure
+                continue
+            }
+            slog.Error("Failed to claim jobs", "error_class", ClassifyError(err), ErrorAttr(err))
+            fatalErr = failures.BackOff(ctx, err)
+            continue
+        }
+        failures.Success()
+        if !acquired {
+            // another replica holds the claim lock, a replica that never gets it makes no progress
+            select {
+            case <-ctx.Done():
+            case <-time.After(claimLockRetryDelay):
+            }
+            continue
+        }
+        health.Progress()
+        claimDuration.Observe(time.Since(start).Seconds())
+        if len(jobs) == 0 {
+            emptyClaims++
+            earliestDueAt, err := c.jobStore.EarliestPendingDueAt(ctx)
+            if err != nil && ctx.Err() == nil {
+                slog.Warn("Failed to get earliest pending job", ErrorAttr(err))
+            }
+            waiter.Wait(ctx, nextPollDelay(emptyClaims, earliestDueAt, time.Now(), minPollInterval, maxPollInterval))
+            continue
+        }
+        emptyClaims = 0
+        jobsClaimed.Add(float64(len(jobs)))
+        observeLateness(jobLateness, jobs, time.Now().UTC())
+
+        queuedAt := time.Now()
+        select {
+        case batches <- claimedBatch{jobs: jobs, claimedAt: start}:
+            dispatchBackpressure.Observe(time.Since(queuedAt).Seconds())
+        case <-ctx.Done():
+            // claimed while shutting down
+            c.releaseJobs(ctx, jobs)
+        }
+    }
+
+    close(batches)
+    <-dispatchDone
+    pool.Close()
+    return fatalErr
+}
+
+// nextPollDelay decides how long to sleep after emptyClaims consecutive empty claims.
+// With nothing pending it sleeps the maximum, with a job due in the future it sleeps until then,
+// and when jobs are due but held by other replicas it backs off exponentially from the minimum.
+func nextPollDelay(emptyClaims int, earliestDueAt *time.Time, now time.Time, minDelay time.Duration, maxDelay time.Duration) time.Duration {
+    if earliestDueAt == nil {
+        return maxDelay
+    }
+    if untilDue := earliestDueAt.Sub(now); untilDue > 0 {
+        return min(max(untilDue, minDelay), maxDelay)
+    }
+    delay := minDelay
+    for i := 1; i < emptyClaims && delay < maxDelay; i++ {
+        delay *= 2
+    }
+    return min(delay, maxDelay)
+}
+
+type pollWaiter struct {
+    notifications <-chan *pq.Notification
+}
+
+func newPollWaiter(notifications <-chan *pq.Notification) *pollWaiter {
+    return &pollWaiter{notifications: notifications}
+}
+
+// Wait sleeps for delay, or until a notified job is due if that comes first.
+func (w *pollWaiter) Wait(ctx context.Context, delay time.Duration) {
+    wakeAt := time.Now().Add(delay)
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-timer.C:
+            return
+        case n := <-w.notifications:
+            if n == nil {
+                // the listener reconnected and may have missed notifications
+                return
+            }
+            dueAt, err := ParseJobsScheduledPayload(n.Extra)
+            if err != nil {
+                slog.Warn("Invalid job notification payload", "payload", n.Extra)
+                return
+            }
+            if !dueAt.Before(wakeAt) {
+                continue
+            }
+            untilDue := time.Until(dueAt)
+            if untilDue <= 0 {
+                return
+            }
+            wakeAt = dueAt
+            if !timer.Stop() {
+                <-timer.C
+            }
+            timer.Reset(untilDue)
+        }
+    }
+}
+
+type claimedBatch struct {
+    jobs      []entity.Job
+    claimedAt time.Time
+}
+
+type dispatchResult struct {
+    job entity.Job
+    err error
+}
+
+// dispatchPool sends jobs to the next service with a fixed number of workers.
+// Dispatch is not safe for concurrent use, batches go through it one at a time.
+type dispatchPool struct {
+    work    chan entity.Job
+    results chan dispatchResult
+}
+
+func newDispatchPool(concurrency int, send func(message entity.JobMessage) error) *dispatchPool {
+    concurrency = max(concurrency, 1)
+    p := &dispatchPool{
+        work: make(chan entity.Job),
+        // buffered so workers never block on a batch that was abandoned at the shutdown deadline
+        results: make(chan dispatchResult, concurrency),
+    }
+    for i := 0; i < concurrency; i++ {
+        go func() {
+            for job := range p.work {
+                // the dispatch span starts a trace of its own, linked to the /schedule-job request that created the job
+                ctx, span := Tracer().Start(context.Background(), "DispatchJob",
+                    trace.WithSpanKind(trace.SpanKindProducer),
+                    trace.WithLinks(trace.Link{SpanContext: SpanContextOf(job.TraceParent)}),
+                    trace.WithAttributes(attribute.Int("job.id", job.Id), attribute.Int("job.attempt", job.Attempts)))
+                message := job.Message()
+                message.TraceParent = TraceParent(ctx)
+
+                start := time.Now()
+                err := send(message)
+                dispatchDuration.Observe(time.Since(start).Seconds())
+                EndSpan(span, err)
+                if err == nil {
+                    observeLateness(jobDispatchLateness, []entity.Job{job}, time.Now().UTC())
+                }
+                p.results <- dispatchResult{job: job, err: err}
+            }
+        }()
+    }
+    return p
+}
+
+// Dispatch sends every job of the batch and blocks until all of them got a result.
+// Once ctx is done no more jobs are handed to the workers and those are returned as unsent;
+// jobs still being sent at that point are in none of the returned lists.
+func (p *dispatchPool) Dispatch(ctx context.Context, jobs []entity.Job) (completedJobs []entity.Job, failedJobs []dispatchResult, unsentJobs []entity.Job) {
+    handedOut := make(chan int, 1)
+    go func() {
+        for i, job := range jobs {
+            if ctx.Err() != nil {
+                handedOut <- i
+                return
+            }
+            select {
+            case p.work <- job:
+            case <-ctx.Done():
+                handedOut <- i
+                return
+            }
+        }
+        handedOut <- len(jobs)
+    }()
+
+    received, sent := 0, -1
+    for sent == -1 || received < sent {
+        select {
+        case result := <-p.results:
+            received++
+            if result.err != nil {
+                failedJobs = append(failedJobs, result)
+            } else {
+                completedJobs = append(completedJobs, result.job)
+            }
+        case sent = <-handedOut:
+            unsentJobs = jobs[sent:]
+        case <-ctx.Done():
+            if sent != -1 {
+                return completedJobs, failedJobs, unsentJobs
+            }
+            // wait for the feeder to report how far it got
+            sent = <-handedOut
+            unsentJobs = jobs[sent:]
+            return completedJobs, failedJobs, unsentJobs
+        }
+    }
+    return completedJobs, failedJobs, unsentJobs
+}
+
+// Close stops the workers once the current batch is done.
+func (p *dispatchPool) Close() {
+    close(p.work)
+}
+
+// claimLockRetryDelay is how long a replica waits after losing the advisory lock to another one
+const claimLockRetryDelay = 50 * time.Millisecond
+
+// observeLateness records in histogram how late each job is at now
+func observeLateness(histogram *prometheus.HistogramVec, jobs []entity.Job, now time.Time) {
+    for _, job := range jobs {
+        histogram.WithLabelValues(latenessLabels(job)...).Observe(now.Sub(job.DueAt).Seconds())
+    }
+}
+
+// latenessLabels returns the tenant type and priority labels of a job. Jobs scheduled without a tenant type are
+// reported as unknown, priorities outside the bands share the "other" priority label to bound the number of series.
+func latenessLabels(job entity.Job) []string {
+    tenantType := job.TenantType
+    if !tenantType.Valid() {
+        tenantType = entity.TenantTypeUnknown
+    }
+    priority := "other"
+    if job.Priority >= 0 && job.Priority < len(entity.TenantTypes) {
+        priority = strconv.Itoa(job.Priority)
+    }
+    return []string{string(tenantType), priority}
+}
+
+func (c *checker) sendJobsNextService(ctx context.Context, pool *dispatchPool, jobs []entity.Job) {
+    if len(jobs) == 0 {
+        return
+    }
+
+    // A job requeued by the job fixer while its first dispatch was still in flight may have been delivered since,
+    // skip it instead of sending it again
+    pendingJobs, deliveredJobs, err := c.filterDelivered(ctx, jobs)
+    if err != nil {
+        slog.Warn("Failed to check recorded deliveries, sending the whole batch", ErrorAttr(err))
+    }
+    if len(deliveredJobs) > 0 {
+        slog.Info("Skipped jobs delivered already", "jobs", len(deliveredJobs))
+        var stale []int
+        if err = RetryTransient(ctx, c.retryPolicy, func() error {
+            var err error
+            stale, err = c.jobStore.CompleteJobs(ctx, deliveredJobs, "delivered already")
+            return err
+        }); err != nil {
+            slog.Error("Failed to update delivered jobs", ErrorAttr(err))
+        }
+        reportStaleClaims("completed", stale)
+    }
+
+    // Jobs claimed too late to be worth sending, e.g. after an outage, are dropped
+    pendingJobs, expiredJobs := splitExpired(pendingJobs, time.Now().UTC())
+    if len(expiredJobs) > 0 {
+        c.expireJobs(ctx, expiredJobs)
+    }
+
+    completedJobs, failedJobs, unsentJobs := pool.Dispatch(ctx, pendingJobs)
+
+    // Shutdown deadline reached, hand the rest back to the other replicas
+    if len(unsentJobs) > 0 {
+        c.releaseJobs(ctx, unsentJobs)
+    }
+    if inFlight := len(pendingJobs) - len(completedJobs) - len(failedJobs) - len(unsentJobs); inFlight > 0 {
+        slog.Warn("Left jobs in progress at the shutdown deadline, the job fixer will requeue them", "jobs", inFlight)
+    }
+
+    // Record the deliveries and complete the jobs, a job left in progress would be sent again after the job fixer's timeout.
+    // The jobs were sent, so the update goes on past the shutdown deadline, bounded by the query timeout alone.
+    if len(completedJobs) > 0 {
+        var stale []int
+        err := RetryTransient(ctx, c.retryPolicy, func() error {
+            var err error
+            stale, err = c.jobStore.CompleteJobs(context.WithoutCancel(ctx), completedJobs, "sent")
+            return err
+        })
+        if err != nil {
+            slog.Error("Failed to update completed jobs", ErrorAttr(err))
+        }
+        reportStaleClaims("completed", stale)
+    }
+
+    // Update failed jobs, the send error is recorded as the reason
+    if len(failedJobs) > 0 {
+        c.failJobs(ctx, failedJobs)
+    }
+    jobsDispatched.Add(float64(len(completedJobs)))
+    jobsFailed.Add(float64(len(failedJobs)))
+}
+
+// failJobs marks jobs that could not be sent as failed, grouped by send error.
+func (c *checker) failJobs(ctx context.Context, failedJobs []dispatchResult) {
+    byReason := map[string][]entity.Job{}
+    for _, result := range failedJobs {
+        slog.Warn("Failed to send job", append(JobAttrs(result.job), "attempt", result.job.Attempts, ErrorAttr(result.err))...)
+        reason := "send failed: " + result.err.Error()
+        byReason[reason] = append(byReason[reason], result.job)
+    }
+    for reason, jobs := range byReason {
+        var stale []int
+        err := RetryTransient(ctx, c.retryPolicy, func() error {
+            var err error
+            stale, err = c.jobStore.FailJobs(ctx, jobs, reason)
+            return err
+        })
+        if err != nil {
+            slog.Error("Failed to update failed jobs", "reason", reason, ErrorAttr(err))
+        }
+        reportStaleClaims("failed", stale)
+    }
+}
+
+// splitExpired separates the jobs past their expires_at at now from those still worth sending.
+func splitExpired(jobs []entity.Job, now time.Time) (pendingJobs []entity.Job, expiredJobs []entity.Job) {
+    for _, job := range jobs {
+        if job.IsExpired(now) {
+            expiredJobs = append(expiredJobs, job)
+        } else {
+            pendingJobs = append(pendingJobs, job)
+        }
+    }
+    return pendingJobs, expiredJobs
+}
+
+// expireJobs marks claimed jobs expired and reports how many were dropped and how late they were.
+func (c *checker) expireJobs(ctx context.Context, jobs []entity.Job) {
+    slog.Info("Dropped expired jobs", "jobs", len(jobs))
+    for _, job := range jobs {
+        slog.Debug("Dropped expired job", append(JobAttrs(job), "due_at", job.DueAt, "expires_at", *job.ExpiresAt)...)
+    }
+    jobsExpired.Add(float64(len(jobs)))
+    observeLateness(expiredJobLateness, jobs, time.Now().UTC())
+
+    var stale []int
+    err := RetryTransient(ctx, c.retryPolicy, func() error {
+        var err error
+        stale, err = c.jobStore.ExpireJobs(ctx, jobs, "expired before it was sent")
+        return err
+    })
+    if err != nil {
+        slog.Error("Failed to update expired jobs", ErrorAttr(err))
+    }
+    reportStaleClaims("expired", stale)
+}
+
+// reportStaleClaims reports the jobs the store left unchanged because the job fixer requeued them while they were
+// dispatched, another claim may own them now.
+func reportStaleClaims(update string, stale []int) {
+    if len(stale) == 0 {
+        return
+    }
+    slog.Warn("Left jobs requeued during dispatch unchanged", "update", update, "jobs", stale)
+    staleClaims.Add(float64(len(stale)))
+}
+
+// filterDelivered splits the jobs between those still to send and those with a recorded delivery.
+// On error every job is returned as pending, sending twice is preferred over not sending.
+func (c *checker) filterDelivered(ctx context.Context, jobs []entity.Job) (pendingJobs []entity.Job, deliveredJobs []entity.Job, err error) {
+    deliveryIds := make([]string, 0, len(jobs))
+    for _, job := range jobs {
+        deliveryIds = append(deliveryIds, job.DeliveryId)
+    }
+
+    delivered, err := c.jobStore.DeliveredIds(ctx, deliveryIds)
+    if err != nil {
+        return jobs, nil, err
+    }
+
+    for _, job := range jobs {
+        if delivered[job.DeliveryId] {
+            deliveredJobs = append(deliveredJobs, job)
+        } else {
+            pendingJobs = append(pendingJobs, job)
+        }
+    }
+    return pendingJobs, deliveredJobs, nil
+}
+
+func jobIds(jobs []entity.Job) []int {
+    ids := make([]int, 0, len(jobs))
+    for _, job := range jobs {
+        ids = append(ids, job.Id)
+    }
+    return ids
+}
+
+// releaseJobs returns claimed but unsent jobs to Initialized so they do not wait for the job fixer's timeout.
+// It runs at shutdown, when ctx is usually done already, so it ignores the cancellation of ctx.
+func (c *checker) releaseJobs(ctx context.Context, jobs []entity.Job) {
+    ctx = context.WithoutCancel(ctx)
+    var released int64
+    err := RetryTransient(ctx, c.retryPolicy, func() error {
+        var err error
+        released, err = c.jobStore.ReleaseJobs(ctx, jobs)
+        return err
+    })
+    if err != nil {
+        slog.Error("Failed to release claimed jobs", "jobs", len(jobs), ErrorAttr(err))
+        return
+    }
+    slog.Info("Released claimed jobs", "jobs", released)
+    if err = c.notify(ctx, time.Now()); err != nil {
+        slog.Warn("Failed to notify released jobs", ErrorAttr(err))
+    }
+}
+
+// SendMessageToQueue publishes the job to the next service. The message carries the job's delivery ID,
+// which stays the same when the job is sent again, for consumers to deduplicate on.
+func SendMessageToQueue(message entity.JobMessage) error {
+    return nil
+}
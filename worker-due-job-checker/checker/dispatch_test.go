@@ -0,0 +1,121 @@
+package checker
+
+import (
+    "context"
+    "errors"
+    . "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "go.opentelemetry.io/otel"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestDispatchPoolBoundsConcurrency(t *testing.T) {
+    const concurrency = 4
+    var inFlight, maxInFlight int64
+    pool := newDispatchPool(concurrency, func(message entity.JobMessage) error {
+        current := atomic.AddInt64(&inFlight, 1)
+        for {
+            seen := atomic.LoadInt64(&maxInFlight)
+            if current <= seen || atomic.CompareAndSwapInt64(&maxInFlight, seen, current) {
+                break
+            }
+        }
+        time.Sleep(5 * time.Millisecond)
+        atomic.AddInt64(&inFlight, -1)
+        if message.JobId%2 == 0 {
+            return errors.New("queue unavailable")
+        }
+        return nil
+    })
+    defer pool.Close()
+
+    jobs := make([]entity.Job, 20)
+    for i := range jobs {
+        jobs[i] = entity.Job{Id: i + 1}
+    }
+
+    completed, failed, unsent := pool.Dispatch(context.Background(), jobs)
+    if len(completed) != 10 || len(failed) != 10 || len(unsent) != 0 {
+        t.Fatalf("Dispatch() completed %d, failed %d and left %d jobs unsent, want 10, 10 and 0", len(completed), len(failed), len(unsent))
+    }
+    for _, result := range failed {
+        if result.job.Id%2 != 0 {
+            t.Errorf("job %d reported as failed", result.job.Id)
+        }
+        if result.err == nil {
+            t.Errorf("job %d reported as failed without its error", result.job.Id)
+        }
+    }
+    if maxInFlight > concurrency {
+        t.Errorf("%d jobs were dispatched concurrently, want at most %d", maxInFlight, concurrency)
+    }
+    if maxInFlight < 2 {
+        t.Errorf("jobs were dispatched sequentially")
+    }
+}
+
+func TestDispatchPoolStopsAtDeadline(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    started := make(chan struct{}, 1)
+    pool := newDispatchPool(1, func(message entity.JobMessage) error {
+        if message.JobId == 1 {
+            started <- struct{}{}
+            <-ctx.Done()
+        }
+        return nil
+    })
+    defer pool.Close()
+
+    jobs := []entity.Job{{Id: 1}, {Id: 2}, {Id: 3}}
+    go func() {
+        <-started
+        cancel()
+    }()
+
+    _, failed, unsent := pool.Dispatch(ctx, jobs)
+    if len(failed) != 0 {
+        t.Errorf("Dispatch() failed %v", failed)
+    }
+    // job 1 was in flight at the deadline and job 2 may have been handed out just before it, job 3 never was
+    if len(unsent) == 0 || unsent[len(unsent)-1].Id != 3 {
+        t.Fatalf("Dispatch() left %v unsent, want job 3 unsent", unsent)
+    }
+    for _, job := range unsent {
+        if job.Id == 1 {
+            t.Errorf("Dispatch() reported the in-flight job 1 as unsent")
+        }
+    }
+}
+
+func TestDispatchSpanLinksSchedulingSpan(t *testing.T) {
+    recorder := tracetest.NewSpanRecorder()
+    previous := otel.GetTracerProvider()
+    otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+    defer otel.SetTracerProvider(previous)
+
+    const schedulingTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+    var sent entity.JobMessage
+    pool := newDispatchPool(1, func(message entity.JobMessage) error {
+        sent = message
+        return nil
+    })
+    defer pool.Close()
+
+    pool.Dispatch(context.Background(), []entity.Job{{Id: 1, TraceParent: schedulingTraceParent}})
+
+    spans := recorder.Ended()
+    if len(spans) != 1 {
+        t.Fatalf("recorded %d spans, want 1", len(spans))
+    }
+    links := spans[0].Links()
+    if len(links) != 1 || links[0].SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+        t.Errorf("dispatch span links %v, want the scheduling span", links)
+    }
+    if dispatchSpan := SpanContextOf(sent.TraceParent); dispatchSpan.SpanID() != spans[0].SpanContext().SpanID() {
+        t.Errorf("message trace parent %q does not carry the dispatch span", sent.TraceParent)
+    }
+}
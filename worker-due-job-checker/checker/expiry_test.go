@@ -0,0 +1,26 @@
+package checker
+
+import (
+    "go-pg-bench/entity"
+    "testing"
+    "time"
+)
+
+func TestSplitExpired(t *testing.T) {
+    now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+    past := now.Add(-time.Minute)
+    future := now.Add(time.Minute)
+    jobs := []entity.Job{
+        {Id: 1, DueAt: now.Add(-time.Hour)},
+        {Id: 2, DueAt: now.Add(-time.Hour), ExpiresAt: &past},
+        {Id: 3, DueAt: now.Add(-time.Hour), ExpiresAt: &future},
+    }
+
+    pending, expired := splitExpired(jobs, now)
+    if len(pending) != 2 || pending[0].Id != 1 || pending[1].Id != 3 {
+        t.Errorf("splitExpired() pending = %v, want jobs 1 and 3", jobIds(pending))
+    }
+    if len(expired) != 1 || expired[0].Id != 2 {
+        t.Errorf("splitExpired() expired = %v, want job 2", jobIds(expired))
+    }
+}
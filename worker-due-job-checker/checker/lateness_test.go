@@ -0,0 +1,46 @@
+package checker
+
+import (
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "go-pg-bench/entity"
+    "testing"
+    "time"
+)
+
+func TestLatenessLabels(t *testing.T) {
+    tests := []struct {
+        job      entity.Job
+        expected []string
+    }{
+        {job: entity.Job{TenantType: entity.TenantTypeNew, Priority: 0}, expected: []string{"new", "0"}},
+        {job: entity.Job{TenantType: entity.TenantTypeSme, Priority: 0}, expected: []string{"sme", "0"}},
+        {job: entity.Job{TenantType: entity.TenantTypeEnterprise, Priority: 2}, expected: []string{"enterprise", "2"}},
+        {job: entity.Job{Priority: 1}, expected: []string{"unknown", "1"}},
+        {job: entity.Job{TenantType: "gold", Priority: 7}, expected: []string{"unknown", "other"}},
+    }
+
+    for _, tt := range tests {
+        got := latenessLabels(tt.job)
+        if len(got) != 2 || got[0] != tt.expected[0] || got[1] != tt.expected[1] {
+            t.Errorf("latenessLabels(%q, priority %d) = %v, want %v", tt.job.TenantType, tt.job.Priority, got, tt.expected)
+        }
+    }
+}
+
+func TestObserveLatenessByTenantType(t *testing.T) {
+    histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_lateness_seconds"},
+        []string{"tenant_type", "priority"})
+    now := time.Now()
+    // same priority, as CalculateNextJobs schedules them, different tenants
+    jobs := []entity.Job{
+        {TenantId: 1, TenantType: entity.TenantTypeNew, DueAt: now.Add(-time.Second)},
+        {TenantId: 2, TenantType: entity.TenantTypeEnterprise, DueAt: now.Add(-time.Minute)},
+        {TenantId: 3, TenantType: entity.TenantTypeEnterprise, DueAt: now.Add(-time.Minute)},
+    }
+    observeLateness(histogram, jobs, now)
+
+    if got := testutil.CollectAndCount(histogram); got != 2 {
+        t.Errorf("collected %d series, want one per tenant type", got)
+    }
+}
@@ -0,0 +1,66 @@
+package checker
+
+import (
+    "context"
+    "github.com/lib/pq"
+    "testing"
+    "time"
+)
+
+func TestNextPollDelay(t *testing.T) {
+    now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+    inTwoSeconds := now.Add(2 * time.Second)
+    inOneHour := now.Add(time.Hour)
+    inOneMillisecond := now.Add(time.Millisecond)
+    oneSecondAgo := now.Add(-time.Second)
+    minDelay, maxDelay := 10*time.Millisecond, 5*time.Second
+
+    tests := []struct {
+        name          string
+        emptyClaims   int
+        earliestDueAt *time.Time
+        expected      time.Duration
+    }{
+        {name: "Nothing pending", emptyClaims: 1, earliestDueAt: nil, expected: maxDelay},
+        {name: "Sleep until next job is due", emptyClaims: 1, earliestDueAt: &inTwoSeconds, expected: 2 * time.Second},
+        {name: "Next job far ahead", emptyClaims: 1, earliestDueAt: &inOneHour, expected: maxDelay},
+        {name: "Next job due almost now", emptyClaims: 1, earliestDueAt: &inOneMillisecond, expected: minDelay},
+        {name: "Due jobs held elsewhere, first retry", emptyClaims: 1, earliestDueAt: &oneSecondAgo, expected: minDelay},
+        {name: "Due jobs held elsewhere, backing off", emptyClaims: 4, earliestDueAt: &oneSecondAgo, expected: 80 * time.Millisecond},
+        {name: "Backoff is capped", emptyClaims: 100, earliestDueAt: &oneSecondAgo, expected: maxDelay},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := nextPollDelay(tt.emptyClaims, tt.earliestDueAt, now, minDelay, maxDelay)
+            if got != tt.expected {
+                t.Errorf("nextPollDelay() = %v, want %v", got, tt.expected)
+            }
+        })
+    }
+}
+
+func TestPollWaiterWakesUpOnSoonerJob(t *testing.T) {
+    notifications := make(chan *pq.Notification, 2)
+    waiter := newPollWaiter(notifications)
+
+    // a job due later than the current sleep is ignored, one that is already due wakes the waiter
+    notifications <- &pq.Notification{Extra: time.Now().Add(time.Hour).Format(time.RFC3339Nano)}
+    notifications <- &pq.Notification{Extra: time.Now().Format(time.RFC3339Nano)}
+
+    start := time.Now()
+    waiter.Wait(context.Background(), time.Minute)
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Errorf("Wait() returned after %v, want an immediate wake up", elapsed)
+    }
+}
+
+func TestPollWaiterSleepsWithoutNotifications(t *testing.T) {
+    waiter := newPollWaiter(nil)
+
+    start := time.Now()
+    waiter.Wait(context.Background(), 20*time.Millisecond)
+    if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+        t.Errorf("Wait() returned after %v, want at least 20ms", elapsed)
+    }
+}
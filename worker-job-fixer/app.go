@@ -1,61 +1,63 @@
 package main
 
 import (
-    "fmt"
-    _ "github.com/lib/pq"
+    "context"
     . "go-pg-bench/common"
-    "go-pg-bench/entity"
-    "log"
+    "go-pg-bench/config"
+    "go-pg-bench/store"
+    "go-pg-bench/worker-job-fixer/fixer"
+    "log/slog"
+    "net/http"
     "time"
 )
 
 func main() {
-    LoadEnv()
-    conn := GetDBConnection()
+    var cfg config.JobFixer
+    config.MustLoad(&cfg)
+    SetupLogger("job-fixer", cfg.Logging)
+    conn := ConnectDB(cfg.Database)
     defer func() {
         if err := conn.Close(); err != nil {
-            log.Fatal(err)
+            Fatal("Failed to close the database", ErrorAttr(err))
         }
     }()
-    m := GetEnvInt("JOB_MAXIMUM_PROCESSING_TIME_IN_SECONDS", 15)
-    maxTimeProcessing := fmt.Sprintf("%d seconds", m)
-
-    for {
-        // DELETE completed jobs
-        // We should archive completed jobs instead of deleting them
-        // But this is testing code, so we just delete them
-        delRes, err := conn.Exec(`DELETE FROM jobs WHERE status = $1`, entity.JobStatusCompleted)
-        if err != nil {
-            log.Fatal("Failed to delete completed jobs", err)
-        }
-        deleted, err := delRes.RowsAffected()
-        log.Println("Deleted jobs: ", deleted, err)
-
-        // Select job exceeding processing time limit and update them to Initialized status to get  reprocessed
-        // NOW() is at utc already
-        query := fmt.Sprintf(`
-          UPDATE jobs 
-          SET status = $1, 
-              due_at = NOW()
-          WHERE id IN (
-              SELECT id FROM jobs
-              WHERE (status = $2 AND NOW() - jobs.due_at > INTERVAL '%s') 
-                OR status = $3
-          )`, maxTimeProcessing) // Use string formatting to include the interval in the query
-
-        updRes, err := conn.Exec(query,
-            entity.JobStatusInitialized,
-            entity.JobStatusInProgress,
-            entity.JobStatusFailed,
-        )
-        if err != nil {
-            log.Fatal("Failed to update jobs", err)
-        }
+    jobStore, err := store.NewPostgres(conn, store.PostgresOptions{Actor: ActorName("job-fixer")})
+    if err != nil {
+        Fatal("Failed to create the job store", ErrorAttr(err))
+    }
 
-        updated, err := updRes.RowsAffected()
-        log.Println("Updated jobs: ", updated, err)
+    fixer.RegisterMetrics()
+    // metrics, health and readiness are served on JOB_FIXER_HTTP_ADDR
+    health := NewHealth(conn, cfg.Health.MaxStall)
+    mux := http.NewServeMux()
+    health.Register(mux)
+    flushMetrics := StartMetrics("job_fixer", mux, cfg.Metrics)
+    defer flushMetrics()
+    if cfg.HTTPAddr != "" {
+        ServeHTTP(cfg.HTTPAddr, mux)
+    }
 
-        log.Printf("Sleeping... for %s seconds\n", maxTimeProcessing)
-        time.Sleep(time.Duration(m) * time.Second)
+    // On SIGTERM finish the current pass and exit instead of sleeping, the pass is cancelled at the shutdown timeout
+    stop, drain := ShutdownContexts(cfg.Shutdown.Timeout)
+    err = fixer.Run(stop, drain, fixer.Options{
+        JobStore:          jobStore,
+        MaxProcessingTime: cfg.MaxProcessingTime,
+        Retention: store.Retention{
+            Jobs:       time.Duration(cfg.CompletedRetentionDays) * 24 * time.Hour,
+            Deliveries: time.Duration(cfg.DeliveryRetentionDays) * 24 * time.Hour,
+            Events:     time.Duration(cfg.EventRetentionDays) * 24 * time.Hour,
+        },
+        MaxAttempts: cfg.MaxAttempts,
+        MaintainPartitions: func(ctx context.Context) error {
+            return MaintainJobPartitions(ctx, conn, time.Now(), cfg.PartitionDaysAhead, cfg.PartitionRetentionDays)
+        },
+        PartitionMaintenanceInterval: cfg.PartitionMaintenanceInterval,
+        Retry:                        NewFailurePolicy(cfg.Retry),
+        Notify:                       NotifyOn(conn),
+        Health:                       health,
+    })
+    if err != nil {
+        Fatal("Giving up", ErrorAttr(err))
     }
+    slog.Info("Shutting down...")
 }
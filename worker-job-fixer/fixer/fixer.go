@@ -0,0 +1,171 @@
+// Package fixer requeues stuck and failed jobs, resolves job dependencies and cleans up old jobs, see Run.
+package fixer
+
+import (
+    "context"
+    "fmt"
+    "github.com/prometheus/client_golang/prometheus"
+    . "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "log/slog"
+    "strconv"
+    "time"
+)
+
+var (
+    jobsExpired = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "job_fixer_jobs_expired_total",
+        Help: "Queued jobs marked expired before they were claimed",
+    })
+    oldestOverdueJob = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "oldest_overdue_job_seconds",
+        Help: "How long the oldest claimable overdue job of each priority band has been waiting",
+    }, []string{"priority"})
+)
+
+// Options configures Run.
+type Options struct {
+    JobStore store.JobStore
+    // MaxProcessingTime is how long a job stays in progress before it is requeued, and the pause between passes
+    MaxProcessingTime time.Duration
+    Retention         store.Retention
+    MaxAttempts       int
+    // MaintainPartitions creates upcoming job partitions and detaches old ones every PartitionMaintenanceInterval,
+    // nil when the store is not partitioned
+    MaintainPartitions           func(ctx context.Context) error
+    PartitionMaintenanceInterval time.Duration
+    // Retry bounds the retries of a failed pass
+    Retry FailurePolicy
+    // Notify wakes the due-job checkers up when jobs were requeued
+    Notify NotifyFunc
+    Health *Health
+}
+
+// RegisterMetrics registers the metrics of the job fixer with the default registry.
+func RegisterMetrics() {
+    RegisterWorkerMetrics()
+    prometheus.MustRegister(jobsExpired, oldestOverdueJob)
+}
+
+// Run fixes jobs every MaxProcessingTime until ctx is done, a pass still running then is cancelled when drainCtx is done.
+// It returns an error when transient failures went on for too long.
+func Run(ctx context.Context, drainCtx context.Context, options Options) error {
+    var lastPartitionMaintenance time.Time
+    failures := NewFailureTracker(options.Retry)
+
+    for ctx.Err() == nil {
+        // Create upcoming daily partitions and detach old ones, a failure is retried on the next run
+        if options.MaintainPartitions != nil && time.Since(lastPartitionMaintenance) >= options.PartitionMaintenanceInterval {
+            if err := options.MaintainPartitions(drainCtx); err != nil {
+                if drainCtx.Err() != nil {
+                    break
+                }
+                slog.Error("Failed to maintain job partitions", ErrorAttr(err))
+            } else {
+                lastPartitionMaintenance = time.Now()
+            }
+        }
+
+        // Transient failures are retried with backoff, the fixer gives up after too many failures in a row
+        requeued, err := fixJobs(drainCtx, options.JobStore, options)
+        if err != nil {
+            if drainCtx.Err() != nil {
+                // the pass was cancelled at the shutdown timeout, each statement is its own transaction
+                break
+            }
+            slog.Error("Failed to fix jobs", "error_class", ClassifyError(err), ErrorAttr(err))
+            if fatalErr := failures.BackOff(ctx, err); fatalErr != nil {
+                return fatalErr
+            }
+            continue
+        }
+        failures.Success()
+        options.Health.Progress()
+        if requeued > 0 {
+            // requeued jobs are due now, released ones may have been due for a while
+            if err = options.Notify(drainCtx, time.Now()); err != nil {
+                slog.Warn("Failed to notify requeued jobs", ErrorAttr(err))
+            }
+        }
+
+        if err = collectOldestOverdueJobs(drainCtx, options.JobStore, len(entity.TenantTypes)); err != nil {
+            slog.Warn("Failed to collect the oldest overdue jobs", ErrorAttr(err))
+        }
+
+        slog.Debug("Sleeping...", "duration", options.MaxProcessingTime.String())
+        _ = Sleep(ctx, options.MaxProcessingTime)
+    }
+    return nil
+}
+
+// fixJobs deletes completed jobs, abandons jobs that failed or timed out MaxAttempts times, expires queued jobs past
+// their expires_at, resolves job dependencies and requeues jobs stuck in progress longer than MaxProcessingTime
+// or failed ones.
+// It returns the number of jobs made claimable again, requeued or released from their dependencies.
+func fixJobs(ctx context.Context, jobStore store.JobStore, options Options) (int64, error) {
+    archived, err := jobStore.ArchiveJobs(ctx, options.Retention)
+    if err != nil {
+        return 0, err
+    }
+
+    // Failed jobs out of attempts are not retried anymore, their dependents get cancelled or skipped below
+    abandoned, err := jobStore.AbandonJobs(ctx, options.MaxAttempts)
+    if err != nil {
+        return 0, fmt.Errorf("failed to abandon jobs: %w", err)
+    }
+
+    // Jobs past their expires_at are not claimed anymore, their dependents get cancelled or skipped below
+    expired, err := jobStore.ExpireQueuedJobs(ctx)
+    if err != nil {
+        return 0, fmt.Errorf("failed to expire jobs: %w", err)
+    }
+    jobsExpired.Add(float64(expired))
+
+    released, err := jobStore.ResolveDependencies(ctx)
+    if err != nil {
+        return 0, fmt.Errorf("failed to resolve job dependencies: %w", err)
+    }
+
+    // Jobs in progress for longer than the processing time limit since they were claimed, or failed ones,
+    // go back to Initialized to get reprocessed with the same delivery id. Stuck jobs out of attempts are abandoned
+    // instead, their dependents get cancelled or skipped on the next pass
+    timedOut, timedOutAbandoned, err := jobStore.RequeueStuckJobs(ctx, options.MaxProcessingTime, options.MaxAttempts)
+    if err != nil {
+        return 0, fmt.Errorf("failed to requeue timed out jobs: %w", err)
+    }
+    retried, err := jobStore.RetryFailedJobs(ctx)
+    if err != nil {
+        return 0, fmt.Errorf("failed to requeue failed jobs: %w", err)
+    }
+
+    slog.Info("Fixed jobs",
+        "deleted_jobs", archived.Jobs,
+        "deleted_deliveries", archived.Deliveries,
+        "deleted_events", archived.Events,
+        "abandoned_jobs", abandoned+timedOutAbandoned,
+        "expired_jobs", expired,
+        "released_dependencies", released,
+        "timed_out_jobs", timedOut,
+        "retried_jobs", retried,
+    )
+    return timedOut + retried + released, nil
+}
+
+// collectOldestOverdueJobs reports how long the oldest claimable overdue job of each priority band has been waiting,
+// with priority aging this stays bounded for every band. Tenant type bands without overdue jobs report 0, the other
+// bands are dropped once they have none.
+func collectOldestOverdueJobs(ctx context.Context, jobStore store.JobStore, priorityBands int) error {
+    oldest, err := jobStore.OldestOverdueJobs(ctx)
+    if err != nil {
+        return err
+    }
+    oldestOverdueJob.Reset()
+    for band := 0; band < priorityBands; band++ {
+        oldestOverdueJob.WithLabelValues(strconv.Itoa(band)).Set(0)
+    }
+    for priority, overdue := range oldest {
+        oldestOverdueJob.WithLabelValues(strconv.Itoa(priority)).Set(overdue.Seconds())
+    }
+    return nil
+}
@@ -0,0 +1,61 @@
+package fixer
+
+import (
+    "context"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+    "go-pg-bench/common"
+    "go-pg-bench/entity"
+    "go-pg-bench/store"
+    "testing"
+    "time"
+)
+
+func TestCollectOldestOverdueJobsByPriorityLabel(t *testing.T) {
+    ctx := context.Background()
+    jobStore := store.NewMemory("test")
+    dueAt := time.Now().Add(-time.Minute)
+    templates := []entity.Job{{DueAt: dueAt, Priority: 1, Metadata: "{}"}, {DueAt: dueAt, Priority: 4, Metadata: "{}"}}
+    if _, err := jobStore.InsertJobs(ctx, templates, entity.Sequence{Id: "sequence", Subscribers: 1}); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := collectOldestOverdueJobs(ctx, jobStore, 3); err != nil {
+        t.Fatal(err)
+    }
+    // bands 0 to 2 are configured, band 4 comes from the data
+    if got := testutil.CollectAndCount(oldestOverdueJob); got != 4 {
+        t.Errorf("collected %d series, want 4", got)
+    }
+    if got := testutil.ToFloat64(oldestOverdueJob.WithLabelValues("0")); got != 0 {
+        t.Errorf("priority 0 without overdue jobs = %v, want 0", got)
+    }
+    if got := testutil.ToFloat64(oldestOverdueJob.WithLabelValues("1")); got < 60 {
+        t.Errorf("priority 1 = %v, want at least 60 seconds", got)
+    }
+
+    // once the band 4 job is done its series is dropped
+    jobs, _, err := jobStore.ClaimDueJobs(ctx, 10, common.PriorityAging{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var sent, unsent []entity.Job
+    for _, job := range jobs {
+        if job.Priority == 4 {
+            sent = append(sent, job)
+        } else {
+            unsent = append(unsent, job)
+        }
+    }
+    if _, err = jobStore.CompleteJobs(ctx, sent, "sent"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err = jobStore.ReleaseJobs(ctx, unsent[:1]); err != nil {
+        t.Fatal(err)
+    }
+    if err = collectOldestOverdueJobs(ctx, jobStore, 3); err != nil {
+        t.Fatal(err)
+    }
+    if got := testutil.CollectAndCount(oldestOverdueJob); got != 3 {
+        t.Errorf("collected %d series after the band 4 job completed, want 3", got)
+    }
+}